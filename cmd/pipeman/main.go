@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/pedro-r-marques/pipeline/pkg/pipeline"
 )
@@ -14,13 +17,48 @@ var (
 	httpStaticDir string
 	httpPort      int
 	jobConfigFile string
+	maxRunning    int
+	preemption    bool
+	httpHeaders   string
+	templateEnv   string
 )
 
+// loadHTTPHeaders reads the http headers of hosts from a file, one per line
+// in the form host=Name: value. Blank lines and lines starting with # are
+// ignored. The headers are read from a file so that credentials are not
+// visible in the command line of the process.
+func loadHTTPHeaders(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		elements := strings.SplitN(line, "=", 2)
+		if len(elements) < 2 || !strings.Contains(elements[1], ":") {
+			return fmt.Errorf("%s:%d: expected host=Name: value", filename, n)
+		}
+		header := strings.SplitN(elements[1], ":", 2)
+		pipeline.SetHTTPHeader(strings.TrimSpace(elements[0]), strings.TrimSpace(header[0]), strings.TrimSpace(header[1]))
+	}
+	return scanner.Err()
+}
+
 func init() {
 	flag.StringVar(&dataDir, "data-dir", "/etc", "Directory for pipeline configuration")
 	flag.StringVar(&httpStaticDir, "http-static-dir", "/var/www", "Directory for static web files")
 	flag.IntVar(&httpPort, "port", 8080, "HTTP port")
 	flag.StringVar(&jobConfigFile, "config", "file:///data/config.json", "Job configuration")
+	flag.IntVar(&maxRunning, "max-running-instances", 0, "Maximum number of running instances across pipelines (0 for no limit)")
+	flag.BoolVar(&preemption, "preemption", false, "Pause lower priority instances when the running instance limit is reached")
+	flag.StringVar(&httpHeaders, "http-headers-file", "", "File with the headers sent to http(s) and git hosts, one per line as host=Name: value")
+	flag.StringVar(&templateEnv, "template-env", "", "Comma separated environment variables that templates can read with env and envOr")
 }
 
 func redirectHandler(w http.ResponseWriter, r *http.Request) {
@@ -30,7 +68,21 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 func main() {
 	flag.Parse()
 
+	if httpHeaders != "" {
+		if err := loadHTTPHeaders(httpHeaders); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, name := range strings.Split(templateEnv, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			pipeline.AllowTemplateEnv(name)
+		}
+	}
+
 	exec := pipeline.NewExecutor(dataDir)
+	exec.SetMaxRunningInstances(maxRunning)
+	exec.SetPreemption(preemption)
 	if jobConfigFile != "" {
 		if err := exec.Configure(jobConfigFile); err != nil {
 			log.Println(err)
@@ -22,6 +22,22 @@ function loadInstanceStatusView(taskStatusElement, instance) {
         element.attr('class', 'col-sm-3');
         taskStatusElement.append(element);
     }
+
+    if (instance.Clone) {
+        var clone = instance.Clone;
+        var element = $('<div>');
+        element.attr('class', 'col-sm-12');
+        element.append('Clone of ' + clone.Source.Pipeline + ':' + clone.Source.ID + ': ' + clone.State +
+            ' (' + clone.Copied + '/' + clone.Total + ' copied, ' + clone.Failed + ' failed)');
+        if (clone.Errors) {
+            var list = $('<ul>');
+            for (var i = 0; i < clone.Errors.length; i++) {
+                list.append($('<li>').text(clone.Errors[i]));
+            }
+            element.append(list);
+        }
+        taskStatusElement.append(element);
+    }
 }
 
 function loadInstanceJobView(jobStatusTable, instance) {
@@ -50,7 +66,34 @@ function loadInstanceJobView(jobStatusTable, instance) {
 
 }
 
-function loadInstanceView(stageElement, taskNameElement, taskStatusElement, jobStatusTable) {
+function loadInstancePodView(podStatusTable, instance, taskName) {
+    var tbody = podStatusTable.find('tbody');
+    tbody.empty();
+
+    var task = instance.TaskList[instance.Stage];
+    if (!task || !task.Pods) {
+        return
+    }
+    var url = "../api/pipeline/" + getUrlParameter('pipeline') + "/instance/" + instance.ID +
+        "/task/" + taskName + "/logs?pod=";
+    var names = Object.keys(task.Pods).sort();
+    for (var i = 0; i < names.length; i++) {
+        var pod = task.Pods[names[i]];
+        var row = $('<tr>');
+        tbody.append(row);
+        row.append($('<td>').text(pod.Name));
+        row.append($('<td>').text(pod.Phase || ''));
+        row.append($('<td>').text(pod.Reason || ''));
+        row.append($('<td>').text(pod.ExitCode != null ? pod.ExitCode : ''));
+        var href = url + encodeURIComponent(pod.Name);
+        if (pod.Phase == 'Running') {
+            href += '&follow=true';
+        }
+        row.append($('<td>').append($('<a>').attr('href', href).attr('target', '_blank').text('log')));
+    }
+}
+
+function loadInstanceView(stageElement, taskNameElement, taskStatusElement, jobStatusTable, podStatusTable) {
     var pipelineName = getUrlParameter('pipeline');
     var instanceID = parseInt(getUrlParameter('id'));
 
@@ -80,6 +123,8 @@ function loadInstanceView(stageElement, taskNameElement, taskStatusElement, jobS
             loadInstanceStatusView(taskStatusElement, instance);
 
             loadInstanceJobView(jobStatusTable, instance);
+
+            loadInstancePodView(podStatusTable, instance, response.spec.Tasks[instance.Stage].Name);
         },
         error: function(jqXHR, textStatus, errorThrown) {
             console.log(textStatus);
@@ -87,7 +132,7 @@ function loadInstanceView(stageElement, taskNameElement, taskStatusElement, jobS
     });
 }
 
-function cloneInstance(includeVal, excludeVal) {
+function cloneInstance(includeVal, excludeVal, startVal) {
     var pipelineName = getUrlParameter('pipeline');
     var instanceID = getUrlParameter('id');
 
@@ -97,13 +142,16 @@ function cloneInstance(includeVal, excludeVal) {
         'include': includeVal,
         'exclude': excludeVal,
     }
+    if (startVal !== undefined && startVal !== '') {
+        data['start'] = parseInt(startVal);
+    }
     $.ajax({
         type: "put",
         url: "../api/clone",
         data: JSON.stringify(data),
         dataType: "json",
         success: function (response) {
-            console.debug(response);
+            window.location.href = 'instance.html?pipeline=' + pipelineName + '&id=' + response.ID;
         },
         error: function(jqXHR, textStatus, errorThrown) {
             console.log(errorThrown);
@@ -180,4 +228,65 @@ function loadTaskActionTable(actionTable) {
             }
         });
      });
-}
\ No newline at end of file
+}
+var workDirPage = {prefix: '', tokens: [''], page: 0, limit: 50};
+
+function formatSize(size) {
+    var units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    var i = 0;
+    while (size >= 1024 && i < units.length - 1) {
+        size /= 1024;
+        i++;
+    }
+    return (i == 0 ? size : size.toFixed(1)) + ' ' + units[i];
+}
+
+function loadWorkDir(workDirTable, summaryElement, prefix, page) {
+    var pipelineName = getUrlParameter('pipeline');
+    var instanceID = getUrlParameter('id');
+    if (page <= 0 || prefix != workDirPage.prefix) {
+        page = 0;
+        workDirPage.tokens = [''];
+    }
+
+    $.ajax({
+        type: "get",
+        url: "../api/workdir/" + pipelineName + "/" + instanceID,
+        data: {'prefix': prefix, 'token': workDirPage.tokens[page], 'limit': workDirPage.limit},
+        dataType: "json",
+        success: function(response) {
+            workDirPage.prefix = prefix;
+            workDirPage.page = page;
+            workDirPage.tokens.length = page + 1;
+            if (response.Next) {
+                workDirPage.tokens.push(response.Next);
+            }
+
+            summaryElement.empty();
+            summaryElement.append(response.WorkDir + ': page ' + (page + 1));
+
+            var tbody = workDirTable.find('tbody');
+            tbody.empty();
+            var objects = response.Objects || [];
+            for (var i = 0; i < objects.length; i++) {
+                var obj = objects[i];
+                var row = $('<tr>');
+                tbody.append(row);
+                var link = $('<a>');
+                link.attr('href', "../api/download/" + pipelineName + "/" + instanceID +
+                    "?name=" + encodeURIComponent(obj.Name));
+                link.text(obj.Name);
+                row.append($('<td>').append(link));
+                row.append($('<td>').append(formatSize(obj.Size)));
+                row.append($('<td>').append(new Date(obj.Updated).toLocaleString()));
+            }
+
+            $('#workdir-prev').toggleClass('disabled', page == 0);
+            $('#workdir-next').toggleClass('disabled', !response.Next);
+        },
+        error: function(jqXHR, textStatus, errorThrown) {
+            summaryElement.empty();
+            summaryElement.text(jqXHR.responseText);
+        }
+    });
+}
@@ -3,7 +3,10 @@ package pipeline
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
@@ -34,6 +37,16 @@ const (
 	ActionStart StateAction = "start"
 	// ActionStop directs the pipeline controller to stop executing the specified pipeline.
 	ActionStop StateAction = "stop"
+	// ActionApprove completes the approval task an instance is waiting on.
+	ActionApprove StateAction = "approve"
+	// ActionReject aborts the instance waiting on an approval task.
+	ActionReject StateAction = "reject"
+	// ActionPause scales down the jobs of the current task of an instance.
+	ActionPause StateAction = "pause"
+	// ActionResume restores the jobs of a paused instance.
+	ActionResume StateAction = "resume"
+	// ActionUpgrade moves a stopped instance to the current pipeline config.
+	ActionUpgrade StateAction = "upgrade"
 )
 
 // StateRequest is the API used to change the execution status of a pipeline.
@@ -41,6 +54,13 @@ type StateRequest struct {
 	Action StateAction
 	ID     int
 	Stage  int
+	// Params are the run parameters of a new instance.
+	Params map[string]string `json:",omitempty"`
+	// Approver and Comment are recorded by approve and reject actions.
+	Approver string `json:",omitempty"`
+	Comment  string `json:",omitempty"`
+	// Priority overrides the pipeline priority of a new instance.
+	Priority *int `json:",omitempty"`
 }
 
 // APIServer implements http.HandlerFunc
@@ -124,7 +144,7 @@ func (svc *APIServer) getPipeline(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if pipeline := svc.exec.PipelineLookup(pipeName); pipeline != nil {
-		js, err := json.Marshal(pipeline)
+		js, err := svc.exec.Marshal(pipeline)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -146,11 +166,8 @@ func (svc *APIServer) putPipeline(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if pipeline := svc.exec.PipelineLookup(pipeName); pipeline != nil {
-		if pipeline.State != StateStopped {
-			http.Error(w, fmt.Sprintf("Pipeline reload in invalid state: %s", pipeline.State), http.StatusBadRequest)
-			return
-		}
-		if err := svc.exec.PipelineReload(pipeline); err != nil {
+		// running instances keep executing with the config they started with
+		if err := svc.exec.PipelineReload(pipeline, requestUser(r)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	} else {
@@ -174,10 +191,15 @@ func (svc *APIServer) deletePipeline(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, pipeName, http.StatusNotFound)
 	}
 	if request.InstanceID != 0 {
-		if instance := p.getInstance(request.InstanceID); instance == nil {
+		instance := p.getInstance(request.InstanceID)
+		if instance == nil {
 			http.Error(w, fmt.Sprintf("invalid instance %d", request.InstanceID), http.StatusNotFound)
 			return
 		}
+		if instance.isCloning() {
+			http.Error(w, fmt.Sprintf("Instance %d is being cloned", request.InstanceID), http.StatusBadRequest)
+			return
+		}
 		svc.exec.DeleteInstance(p, request.InstanceID)
 	} else {
 		if len(p.Instances) > 0 {
@@ -197,7 +219,7 @@ func (svc *APIServer) getPipelines(w http.ResponseWriter, r *http.Request) {
 	for i, k := range keys {
 		result[i] = svc.exec.PipelineLookup(k)
 	}
-	js, err := json.Marshal(&result)
+	js, err := svc.exec.Marshal(&result)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -241,6 +263,9 @@ func (svc *APIServer) putState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// restarts execute with the configuration the instance was started with
+	spec := pipeline.Config.Spec
+
 	switch request.Action {
 	case ActionStart:
 		if request.ID > 0 {
@@ -249,10 +274,15 @@ func (svc *APIServer) putState(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, fmt.Sprintf("invalid instance id: %d", request.ID), http.StatusBadRequest)
 				return
 			}
-			if instance.State == StateRunning {
+			if instance.isActive() {
 				http.Error(w, fmt.Sprintf("Invalid state for start operation: %s", instance.State), http.StatusBadRequest)
 				return
 			}
+			if len(request.Params) > 0 || request.Priority != nil {
+				http.Error(w, "parameters and priority can only be specified for new instances", http.StatusBadRequest)
+				return
+			}
+			spec = pipeline.instanceSpec(instance)
 		}
 
 	case ActionStop:
@@ -264,18 +294,468 @@ func (svc *APIServer) putState(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+	case ActionApprove, ActionReject:
+		instance := pipeline.getInstance(request.ID)
+		if instance == nil {
+			http.Error(w, fmt.Sprintf("invalid instance id: %d", request.ID), http.StatusBadRequest)
+			return
+		}
+		if instance.State != StateWaitingApproval {
+			http.Error(w, fmt.Sprintf("Invalid state for %s operation: %s", request.Action, instance.State), http.StatusBadRequest)
+			return
+		}
+		if request.Approver == "" {
+			http.Error(w, "approver must be specified", http.StatusBadRequest)
+			return
+		}
+
+	case ActionPause, ActionResume:
+		instance := pipeline.getInstance(request.ID)
+		if instance == nil {
+			http.Error(w, fmt.Sprintf("invalid instance id: %d", request.ID), http.StatusBadRequest)
+			return
+		}
+		expected := StateRunning
+		if request.Action == ActionResume {
+			expected = StatePaused
+		}
+		if instance.State != expected {
+			http.Error(w, fmt.Sprintf("Invalid state for %s operation: %s", request.Action, instance.State), http.StatusBadRequest)
+			return
+		}
+
+	case ActionUpgrade:
+		instance := pipeline.getInstance(request.ID)
+		if instance == nil {
+			http.Error(w, fmt.Sprintf("invalid instance id: %d", request.ID), http.StatusBadRequest)
+			return
+		}
+		if instance.isActive() {
+			http.Error(w, fmt.Sprintf("Invalid state for upgrade operation: %s", instance.State), http.StatusBadRequest)
+			return
+		}
+
 	default:
 		http.Error(w, string(request.Action), http.StatusBadRequest)
 		return
 	}
 
-	if request.Stage >= len(pipeline.Config.Spec.Tasks) {
+	if request.Stage >= len(spec.Tasks) {
 		http.Error(w, fmt.Sprintf("invalid stage id %d", request.Stage), http.StatusBadRequest)
 		return
 	}
 
-	if err := svc.exec.SetState(pipeline, request.Action, request.ID, request.Stage); err != nil {
+	if err := svc.exec.SetState(pipeline, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (svc *APIServer) backfillPipeline(w http.ResponseWriter, r *http.Request) *Pipeline {
+	return svc.endpointPipeline(w, r, "backfill")
+}
+
+// endpointPipeline looks up the pipeline in a /<endpoint>/<pipeline> request.
+func (svc *APIServer) endpointPipeline(w http.ResponseWriter, r *http.Request, endpoint string) *Pipeline {
+	elements := strings.Split(r.URL.Path, "/")
+	pipeName := elements[len(elements)-1]
+	if len(elements) < 2 || elements[len(elements)-2] != endpoint {
+		http.Error(w, r.URL.Path, http.StatusNotFound)
+		return nil
+	}
+	pipeline := svc.exec.PipelineLookup(pipeName)
+	if pipeline == nil {
+		http.Error(w, pipeName, http.StatusNotFound)
+	}
+	return pipeline
+}
+
+// instanceEndpoint looks up the instance in a /<endpoint>/<pipeline>/<id>
+// request.
+func (svc *APIServer) instanceEndpoint(w http.ResponseWriter, r *http.Request, endpoint string) (*Pipeline, *Instance) {
+	elements := strings.Split(r.URL.Path, "/")
+	if len(elements) < 3 || elements[len(elements)-3] != endpoint {
+		http.Error(w, r.URL.Path, http.StatusNotFound)
+		return nil, nil
+	}
+	pipeName := elements[len(elements)-2]
+	pipeline := svc.exec.PipelineLookup(pipeName)
+	if pipeline == nil {
+		http.Error(w, pipeName, http.StatusNotFound)
+		return nil, nil
+	}
+	id, err := strconv.Atoi(elements[len(elements)-1])
+	if err != nil {
+		http.Error(w, "Invalid instance id", http.StatusBadRequest)
+		return nil, nil
+	}
+	instance := pipeline.getInstance(id)
+	if instance == nil {
+		http.Error(w, fmt.Sprintf("%s: instance %d not found", pipeName, id), http.StatusNotFound)
+		return nil, nil
+	}
+	return pipeline, instance
+}
+
+func (svc *APIServer) getBackfill(w http.ResponseWriter, r *http.Request) {
+	pipeline := svc.backfillPipeline(w, r)
+	if pipeline == nil {
+		return
+	}
+	js, err := svc.exec.Marshal(&pipeline.Backfills)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func (svc *APIServer) postBackfill(w http.ResponseWriter, r *http.Request) {
+	pipeline := svc.backfillPipeline(w, r)
+	if pipeline == nil {
+		return
+	}
+
+	var request BackfillRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backfill, err := svc.exec.BackfillCreate(pipeline, &request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	js, err := svc.exec.Marshal(backfill)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func (svc *APIServer) putBackfill(w http.ResponseWriter, r *http.Request) {
+	pipeline := svc.backfillPipeline(w, r)
+	if pipeline == nil {
+		return
+	}
+
+	var request BackfillControlRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.exec.BackfillUpdate(pipeline, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// requestUser returns the user name of the request, when known.
+func requestUser(r *http.Request) string {
+	if user := r.URL.Query().Get("user"); user != "" {
+		return user
+	}
+	return r.Header.Get("X-Remote-User")
+}
+
+func (svc *APIServer) getVersions(w http.ResponseWriter, r *http.Request) {
+	pipeline := svc.endpointPipeline(w, r, "versions")
+	if pipeline == nil {
+		return
+	}
+	js, err := svc.exec.Marshal(&pipeline.History)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getDiff compares the spec of two config versions, specified by the "from"
+// and "to" query parameters. "to" defaults to the current version.
+func (svc *APIServer) getDiff(w http.ResponseWriter, r *http.Request) {
+	pipeline := svc.endpointPipeline(w, r, "diff")
+	if pipeline == nil {
+		return
+	}
+	hash := r.URL.Query().Get("from")
+	if hash == "" {
+		http.Error(w, "Query parameter \"from\" must be specified", http.StatusBadRequest)
+		return
+	}
+	from, err := svc.exec.LookupConfig(pipeline, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := svc.exec.LookupConfig(pipeline, r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changes, err := diffSpecs(from.Spec, to.Spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(changes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// RollbackRequest selects the config version to restore.
+type RollbackRequest struct {
+	Hash string
+	User string `json:",omitempty"`
+}
+
+func (svc *APIServer) putRollback(w http.ResponseWriter, r *http.Request) {
+	pipeline := svc.endpointPipeline(w, r, "rollback")
+	if pipeline == nil {
+		return
+	}
+	var request RollbackRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.User == "" {
+		request.User = requestUser(r)
+	}
+	if err := svc.exec.PipelineRollback(pipeline, request.Hash, request.User); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (svc *APIServer) getQueue(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(svc.exec.Queue())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func (svc *APIServer) putBulk(w http.ResponseWriter, r *http.Request) {
+	var request BulkRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := svc.exec.BulkUpdate(&request)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	js, err := json.Marshal(results)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getWorkDir lists the objects in the work directory of an instance. The
+// "prefix" query parameter filters the objects by name and "start" and
+// "limit" select a page of the sorted listing.
+func (svc *APIServer) getWorkDir(w http.ResponseWriter, r *http.Request) {
+	pipeline, instance := svc.instanceEndpoint(w, r, "workdir")
+	if instance == nil {
+		return
+	}
+	workDir := pipeline.instanceWorkDir(instance)
+	if workDir == "" {
+		http.Error(w, "Pipeline has no storage", http.StatusNotFound)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxWorkDirPage {
+			http.Error(w, "Invalid value for query parameter \"limit\"", http.StatusBadRequest)
+			return
+		}
+	}
+	listing, err := listWorkDir(workDir, r.URL.Query().Get("prefix"), r.URL.Query().Get("token"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	js, err := json.Marshal(listing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getDownload returns the content of the work directory object specified by
+// the "name" query parameter.
+func (svc *APIServer) getDownload(w http.ResponseWriter, r *http.Request) {
+	pipeline, instance := svc.instanceEndpoint(w, r, "download")
+	if instance == nil {
+		return
+	}
+	workDir := pipeline.instanceWorkDir(instance)
+	if workDir == "" {
+		http.Error(w, "Pipeline has no storage", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	uri, info, err := statWorkDirObject(workDir, name)
+	if os.IsNotExist(err) {
+		http.Error(w, name, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rd, err := newFileReader(uri)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rd.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	io.Copy(w, rd)
+}
+
+// getLineage returns the instances that produced the object specified by
+// the "uri" query parameter.
+func (svc *APIServer) getLineage(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("uri")
+	if uri == "" {
+		http.Error(w, "Query parameter \"uri\" must be specified", http.StatusBadRequest)
+		return
+	}
+	js, err := json.Marshal(svc.exec.Lineage(uri))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getTaskLogs serves the pods of a task in a
+// /pipeline/<pipeline>/instance/<id>/task/<task>/logs request. The "pod"
+// query parameter selects the log of a pod: the saved tail of a terminated
+// pod or, with "follow=true", the live log of a running pod.
+func (svc *APIServer) getTaskLogs(w http.ResponseWriter, r *http.Request) {
+	elements := strings.Split(r.URL.Path[len(APIServerURLPath):], "/")
+	if len(elements) != 7 || elements[2] != "instance" || elements[4] != "task" || elements[6] != "logs" {
+		http.Error(w, r.URL.Path, http.StatusNotFound)
+		return
+	}
+	pipeline := svc.exec.PipelineLookup(elements[1])
+	if pipeline == nil {
+		http.Error(w, elements[1], http.StatusNotFound)
+		return
+	}
+	id, err := strconv.Atoi(elements[3])
+	if err != nil {
+		http.Error(w, "Invalid instance id", http.StatusBadRequest)
+		return
+	}
+	instance := pipeline.getInstance(id)
+	if instance == nil {
+		http.Error(w, fmt.Sprintf("%s: instance %d not found", pipeline.Name, id), http.StatusNotFound)
+		return
+	}
+	stage := taskIndexByName(pipeline.instanceSpec(instance), elements[5])
+	if stage < 0 || stage >= len(instance.TaskList) {
+		http.Error(w, fmt.Sprintf("%s: task %s not found", pipeline.Name, elements[5]), http.StatusNotFound)
+		return
+	}
+	pods := svc.exec.TaskPods(pipeline, instance, stage)
+
+	podName := r.URL.Query().Get("pod")
+	if podName == "" {
+		js, err := json.Marshal(pods)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+		return
+	}
+	var record *PodRecord
+	for i := range pods {
+		if pods[i].Name == podName {
+			record = &pods[i]
+			break
+		}
+	}
+	if record == nil {
+		http.Error(w, fmt.Sprintf("%s: pod %s not found", pipeline.Name, podName), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case record.LogURI != "":
+		rd, err := newFileReader(record.LogURI)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rd.Close()
+		io.Copy(w, rd)
+	case record.Log != "":
+		io.WriteString(w, record.Log)
+	default:
+		follow := r.URL.Query().Get("follow") == "true" && !record.terminated()
+		rd, err := svc.exec.PodLogs(pipeline, instance, podName, follow)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer rd.Close()
+		flushCopy(w, rd)
+	}
+}
+
+// flushCopy copies a stream to the response, flushing after each read.
+func flushCopy(w http.ResponseWriter, rd io.Reader) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rd.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
 	}
 }
 
@@ -285,6 +765,9 @@ type CloneRequest struct {
 	Instance int    `json:"instance"`
 	Include  string `json:"include"`
 	Exclude  string `json:"exclude"`
+	// Start, when set, is the stage the new instance is started at once
+	// the copy completes.
+	Start *int `json:"start,omitempty"`
 }
 
 func (svc *APIServer) putClone(w http.ResponseWriter, r *http.Request) {
@@ -301,9 +784,18 @@ func (svc *APIServer) putClone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := svc.exec.Clone(pipeline, request.Instance, request.Include, request.Exclude); err != nil {
+	instance, err := svc.exec.Clone(pipeline, &request)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	js, err := svc.exec.Marshal(instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
 }
 
 func (svc *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -313,16 +805,36 @@ func (svc *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		switch elements[0] {
 		case "pipeline":
-			svc.getPipeline(w, r)
+			if len(elements) > 1 && strings.Contains(elements[1], "/") {
+				svc.getTaskLogs(w, r)
+			} else {
+				svc.getPipeline(w, r)
+			}
 		case "pipelines":
 			svc.getPipelines(w, r)
+		case "backfill":
+			svc.getBackfill(w, r)
+		case "queue":
+			svc.getQueue(w, r)
+		case "versions":
+			svc.getVersions(w, r)
+		case "diff":
+			svc.getDiff(w, r)
+		case "workdir":
+			svc.getWorkDir(w, r)
+		case "download":
+			svc.getDownload(w, r)
+		case "lineage":
+			svc.getLineage(w, r)
 		default:
 			http.NotFound(w, r)
 		}
 	case http.MethodPost:
-		switch dest {
+		switch elements[0] {
 		case "pipelines":
 			svc.postPipelines(w, r)
+		case "backfill":
+			svc.postBackfill(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -334,6 +846,12 @@ func (svc *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			svc.putState(w, r)
 		case "clone":
 			svc.putClone(w, r)
+		case "backfill":
+			svc.putBackfill(w, r)
+		case "bulk":
+			svc.putBulk(w, r)
+		case "rollback":
+			svc.putRollback(w, r)
 		default:
 			http.NotFound(w, r)
 		}
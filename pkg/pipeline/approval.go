@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// ApprovalRecord contains the decision on an approval task.
+type ApprovalRecord struct {
+	Task     string
+	Approved bool
+	Approver string
+	Comment  string `json:",omitempty"`
+	Time     time.Time
+}
+
+// startApproval places the instance in the WaitingApproval state. When the
+// task has a timeout, the instance is aborted unless a decision is made in
+// time.
+func (exec *mrExecutor) startApproval(p *Pipeline, instance *Instance, stage int) {
+	instance.State = StateWaitingApproval
+
+	spec := p.instanceSpec(instance).Tasks[stage].Approval
+	if spec.Timeout == "" {
+		return
+	}
+	timeout, err := time.ParseDuration(spec.Timeout)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	done := make(chan struct{})
+	instance.TaskList[stage].done = done
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			exec.events <- &evTaskAbort{p, instance.ID, stage, "Approval timeout", time.Now()}
+		}
+	}()
+}
+
+type evTaskApproval struct {
+	pipeline   *Pipeline
+	instanceID int
+	taskIndex  int
+	approved   bool
+	approver   string
+	comment    string
+}
+
+func (ev *evTaskApproval) eventType() smEventType { return eventTaskApproval }
+func (ev *evTaskApproval) String() string {
+	return fmt.Sprintf("TASK APPROVAL %s:%d task:%d approved:%t", ev.pipeline.Name, ev.instanceID, ev.taskIndex, ev.approved)
+}
+
+func (exec *mrExecutor) handleTaskApproval(event *evTaskApproval) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil || instance.State != StateWaitingApproval || instance.Stage != event.taskIndex {
+		log.Printf("%s:%d unexpected approval for task %d", p.Name, event.instanceID, event.taskIndex)
+		return
+	}
+
+	task := instance.TaskList[event.taskIndex]
+	if task.done != nil {
+		close(task.done)
+		task.done = nil
+	}
+
+	instance.Approvals = append(instance.Approvals, &ApprovalRecord{
+		Task:     p.instanceSpec(instance).Tasks[event.taskIndex].Name,
+		Approved: event.approved,
+		Approver: event.approver,
+		Comment:  event.comment,
+		Time:     time.Now(),
+	})
+	instance.State = StateRunning
+
+	if event.approved {
+		exec.post(&evTaskComplete{p, instance.ID, event.taskIndex, time.Now()})
+	} else {
+		msg := "Rejected by " + event.approver
+		exec.post(&evTaskAbort{p, instance.ID, event.taskIndex, msg, time.Now()})
+	}
+}
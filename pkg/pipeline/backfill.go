@@ -0,0 +1,328 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// maxBackfillValues limits the number of instances created by a backfill.
+	maxBackfillValues = 1000
+)
+
+// BackfillState defines the state of a backfill.
+type BackfillState string
+
+const (
+	// BackfillRunning means that the backfill is creating instances.
+	BackfillRunning BackfillState = "Running"
+	// BackfillPaused means that no new instances are started.
+	BackfillPaused BackfillState = "Paused"
+	// BackfillCancelled means that the backfill was stopped by the user.
+	BackfillCancelled BackfillState = "Cancelled"
+	// BackfillComplete means that an instance completed for every value.
+	BackfillComplete BackfillState = "Complete"
+)
+
+// BackfillAction defines the operations on an existing backfill.
+type BackfillAction string
+
+const (
+	// BackfillActionPause stops the backfill from starting new instances.
+	BackfillActionPause BackfillAction = "pause"
+	// BackfillActionResume resumes a paused backfill.
+	BackfillActionResume BackfillAction = "resume"
+	// BackfillActionCancel stops the backfill and its running instances.
+	BackfillActionCancel BackfillAction = "cancel"
+)
+
+// BackfillRequest specifies the parameters for a POST request on the
+// /backfill/<pipeline> endpoint.
+type BackfillRequest struct {
+	// Param is the name of the pipeline parameter that receives each value.
+	Param string
+	// Start and End define an inclusive date range (YYYY-MM-DD).
+	Start string
+	End   string
+	// Step is the number of days between dates (defaults to 1).
+	Step int
+	// Values is an explicit list of values used instead of a date range.
+	Values []string
+	// MaxRunning is the maximum number of concurrent instances (defaults to 1).
+	MaxRunning int
+	// Params are passed to every instance of the backfill.
+	Params map[string]string
+}
+
+// BackfillControlRequest specifies the parameters for a PUT request on the
+// /backfill/<pipeline> endpoint.
+type BackfillControlRequest struct {
+	ID     int
+	Action BackfillAction
+}
+
+// BackfillProgress counts the backfill values by state.
+type BackfillProgress struct {
+	Total    int
+	Pending  int
+	Running  int
+	Complete int
+	Failed   int
+	Skipped  int
+}
+
+// Backfill executes a pipeline once per value of a parameter.
+type Backfill struct {
+	ID         int
+	Param      string
+	Values     []string
+	Params     map[string]string `json:",omitempty"`
+	MaxRunning int
+	State      BackfillState
+	// Instances maps each value to the instance that processed it.
+	Instances map[string]int
+	// Errors maps the values for which an instance could not be created to
+	// the error. These values are not retried.
+	Errors   map[string]string `json:",omitempty"`
+	Progress BackfillProgress
+}
+
+func backfillDateRange(start, end string, step int) ([]string, error) {
+	from, err := time.Parse(paramDateFormat, start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := time.Parse(paramDateFormat, end)
+	if err != nil {
+		return nil, err
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid date range %s - %s", start, end)
+	}
+	if step == 0 {
+		step = 1
+	}
+	var values []string
+	for dt := from; !dt.After(to); dt = dt.AddDate(0, 0, step) {
+		if len(values) >= maxBackfillValues {
+			return nil, fmt.Errorf("date range exceeds %d values", maxBackfillValues)
+		}
+		values = append(values, dt.Format(paramDateFormat))
+	}
+	return values, nil
+}
+
+func (p *Pipeline) getBackfill(id int) *Backfill {
+	for _, b := range p.Backfills {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) createBackfill(request *BackfillRequest) (*Backfill, error) {
+	if getParamSpec(p.Config.Spec, request.Param) == nil {
+		return nil, fmt.Errorf("unknown parameter %s", request.Param)
+	}
+	if _, ok := request.Params[request.Param]; ok {
+		return nil, fmt.Errorf("parameter %s is set by the backfill", request.Param)
+	}
+	if request.Step < 0 || request.MaxRunning < 0 {
+		return nil, fmt.Errorf("invalid backfill request")
+	}
+
+	var values []string
+	if len(request.Values) > 0 {
+		if request.Start != "" || request.End != "" {
+			return nil, fmt.Errorf("values and date range are mutually exclusive")
+		}
+		if len(request.Values) > maxBackfillValues {
+			return nil, fmt.Errorf("backfill exceeds %d values", maxBackfillValues)
+		}
+		values = request.Values
+	} else {
+		var err error
+		if values, err = backfillDateRange(request.Start, request.End, request.Step); err != nil {
+			return nil, err
+		}
+	}
+
+	// check that the values are valid for the parameter
+	for _, v := range values {
+		params := map[string]string{request.Param: v}
+		for k, v := range request.Params {
+			params[k] = v
+		}
+		if _, err := validateParams(p.Config.Spec, params); err != nil {
+			return nil, err
+		}
+	}
+
+	var max int
+	for _, b := range p.Backfills {
+		if b.ID > max {
+			max = b.ID
+		}
+	}
+	b := &Backfill{
+		ID:         max + 1,
+		Param:      request.Param,
+		Values:     values,
+		Params:     request.Params,
+		MaxRunning: request.MaxRunning,
+		State:      BackfillRunning,
+		Instances:  make(map[string]int),
+	}
+	if b.MaxRunning == 0 {
+		b.MaxRunning = 1
+	}
+	p.Backfills = append(p.Backfills, b)
+	return b, nil
+}
+
+// findCompletedInstance returns an instance that completed with the specified
+// parameter value.
+func (p *Pipeline) findCompletedInstance(param, value string) *Instance {
+	for _, instance := range p.Instances {
+		if instance.State == StateComplete && instance.Params[param] == value {
+			return instance
+		}
+	}
+	return nil
+}
+
+// scheduleBackfill updates the progress of a backfill and starts instances for
+// pending values, up to the concurrency limit of the backfill.
+func (exec *mrExecutor) scheduleBackfill(p *Pipeline, b *Backfill) {
+	exec.Lock()
+	defer exec.Unlock()
+	progress := BackfillProgress{Total: len(b.Values)}
+	var pending []string
+	for _, value := range b.Values {
+		if _, failed := b.Errors[value]; failed {
+			progress.Failed++
+			continue
+		}
+		id, ok := b.Instances[value]
+		if !ok {
+			if instance := p.findCompletedInstance(b.Param, value); instance != nil {
+				b.Instances[value] = instance.ID
+				progress.Skipped++
+				continue
+			}
+			pending = append(pending, value)
+			continue
+		}
+		instance := p.getInstance(id)
+		switch {
+		case instance == nil:
+			progress.Failed++
+		case instance.Backfill != b.ID:
+			progress.Skipped++
+		case instance.State == StateComplete:
+			progress.Complete++
+		case instance.State == "" || instance.isActive():
+			// instances that have not yet started are counted as running
+			progress.Running++
+		default:
+			progress.Failed++
+		}
+	}
+
+	if b.State == BackfillRunning {
+		for len(pending) > 0 && progress.Running < b.MaxRunning {
+			value := pending[0]
+			pending = pending[1:]
+			params := map[string]string{b.Param: value}
+			for k, v := range b.Params {
+				params[k] = v
+			}
+			instance, err := p.createInstance(&instanceOptions{params: params, backfill: b.ID})
+			if err != nil {
+				log.Printf("%s: backfill %d: %v", p.Name, b.ID, err)
+				if b.Errors == nil {
+					b.Errors = make(map[string]string)
+				}
+				b.Errors[value] = err.Error()
+				progress.Failed++
+				continue
+			}
+			b.Instances[value] = instance.ID
+			progress.Running++
+			exec.post(&evPipelineRun{p, instance.ID, 0})
+		}
+		if len(pending) == 0 && progress.Running == 0 {
+			b.State = BackfillComplete
+		}
+	}
+	progress.Pending = len(pending)
+	b.Progress = progress
+}
+
+// updateBackfill applies a control request and returns the event that
+// schedules or cancels the instances of the backfill. Must be called with the
+// lock held.
+func (p *Pipeline) updateBackfill(request *BackfillControlRequest) (smEvent, error) {
+	b := p.getBackfill(request.ID)
+	if b == nil {
+		return nil, fmt.Errorf("Invalid backfill ID %d", request.ID)
+	}
+	switch request.Action {
+	case BackfillActionPause:
+		if b.State != BackfillRunning {
+			return nil, fmt.Errorf("Invalid state for pause operation: %s", b.State)
+		}
+		b.State = BackfillPaused
+		return nil, nil
+	case BackfillActionResume:
+		if b.State != BackfillPaused {
+			return nil, fmt.Errorf("Invalid state for resume operation: %s", b.State)
+		}
+		b.State = BackfillRunning
+		return &evBackfillSchedule{p}, nil
+	case BackfillActionCancel:
+		if b.State == BackfillComplete || b.State == BackfillCancelled {
+			return nil, fmt.Errorf("Invalid state for cancel operation: %s", b.State)
+		}
+		b.State = BackfillCancelled
+		return &evBackfillCancel{p, b.ID}, nil
+	}
+	return nil, fmt.Errorf("unknown backfill action %s", request.Action)
+}
+
+func (exec *mrExecutor) scheduleBackfills(p *Pipeline) {
+	for _, b := range p.Backfills {
+		if b.State == BackfillRunning {
+			exec.scheduleBackfill(p, b)
+		}
+	}
+}
+
+// cancelBackfill stops the running instances of a backfill.
+func (exec *mrExecutor) cancelBackfill(p *Pipeline, b *Backfill) {
+	for _, id := range b.Instances {
+		instance := p.getInstance(id)
+		if instance == nil || instance.Backfill != b.ID || !instance.isActive() {
+			continue
+		}
+		exec.post(&evTaskAbort{p, id, instance.Stage, "Backfill cancelled", time.Now()})
+	}
+}
+
+type evBackfillCancel struct {
+	pipeline   *Pipeline
+	backfillID int
+}
+
+func (ev *evBackfillCancel) eventType() smEventType { return eventBackfillCancel }
+func (ev *evBackfillCancel) String() string {
+	return fmt.Sprintf("BACKFILL CANCEL %s:%d", ev.pipeline.Name, ev.backfillID)
+}
+
+func (exec *mrExecutor) handleBackfillCancel(event *evBackfillCancel) {
+	if b := event.pipeline.getBackfill(event.backfillID); b != nil {
+		exec.cancelBackfill(event.pipeline, b)
+	}
+}
@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackfillDateRange(t *testing.T) {
+	values, err := backfillDateRange("2017-02-27", "2017-03-02", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2017-02-27", "2017-02-28", "2017-03-01", "2017-03-02"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+
+	values, err = backfillDateRange("2017-03-01", "2017-03-10", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"2017-03-01", "2017-03-08"}) {
+		t.Error(values)
+	}
+
+	if _, err := backfillDateRange("2017-03-02", "2017-03-01", 1); err == nil {
+		t.Error("expected error for inverted range")
+	}
+}
+
+func TestBackfillSchedule(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	config := &Config{
+		Spec: &Spec{
+			Name:      "test",
+			Namespace: "roque",
+			Params:    []ParamSpec{{Name: "date", Type: "date", Required: true}},
+			Tasks: []TaskSpec{
+				{
+					Name: "step1",
+					JobTemplate: JobTemplate{
+						Image:       "step1",
+						Instances:   1,
+						Parallelism: 1,
+						Args:        []string{"-date={{.Params.date}}"},
+					},
+				},
+			},
+		},
+	}
+	defaultPipelineSpecValues(config.Spec, "../../templates")
+	pipeline := &Pipeline{
+		Name:   "test",
+		State:  StateStopped,
+		Config: config,
+	}
+
+	previous, err := pipeline.createInstance(&instanceOptions{params: map[string]string{"date": "2017-03-01"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.State = StateComplete
+
+	b, err := pipeline.createBackfill(&BackfillRequest{
+		Param:      "date",
+		Start:      "2017-03-01",
+		End:        "2017-03-04",
+		MaxRunning: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exec.scheduleBackfill(pipeline, b)
+	expected := BackfillProgress{Total: 4, Pending: 1, Running: 2, Skipped: 1}
+	if b.Progress != expected {
+		t.Errorf("expected %+v, got %+v", expected, b.Progress)
+	}
+	if len(exec.events) != 2 {
+		t.Error(len(exec.events))
+	}
+
+	instance := pipeline.getInstance(b.Instances["2017-03-02"])
+	if instance == nil || instance.Params["date"] != "2017-03-02" || instance.Backfill != b.ID {
+		t.Fatalf("unexpected instance %+v", instance)
+	}
+	instance.State = StateComplete
+
+	b.State = BackfillPaused
+	exec.scheduleBackfill(pipeline, b)
+	expected = BackfillProgress{Total: 4, Pending: 1, Running: 1, Complete: 1, Skipped: 1}
+	if b.Progress != expected {
+		t.Errorf("expected %+v, got %+v", expected, b.Progress)
+	}
+	if len(exec.events) != 2 {
+		t.Error(len(exec.events))
+	}
+}
+
+func TestBackfillScheduleOverflow(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	config := &Config{
+		Spec: &Spec{
+			Name:   "test",
+			Params: []ParamSpec{{Name: "date", Type: "date", Required: true}},
+			Tasks:  []TaskSpec{{Name: "step1", JobTemplate: JobTemplate{Image: "step1", Instances: 1, Parallelism: 1}}},
+		},
+	}
+	defaultPipelineSpecValues(config.Spec, "../../templates")
+	pipeline := &Pipeline{Name: "test", State: StateStopped, Config: config}
+
+	b, err := pipeline.createBackfill(&BackfillRequest{
+		Param:      "date",
+		Start:      "2017-03-01",
+		End:        "2017-03-20",
+		MaxRunning: 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the executor must not block when it posts more events than the
+	// channel holds
+	done := make(chan struct{})
+	go func() {
+		exec.scheduleBackfill(pipeline, b)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduleBackfill blocked")
+	}
+	if b.Progress.Running != 20 {
+		t.Errorf("unexpected progress %+v", b.Progress)
+	}
+	if len(exec.events) != 16 || len(exec.overflow) != 4 {
+		t.Fatalf("unexpected events %d, overflow %d", len(exec.events), len(exec.overflow))
+	}
+
+	for i := 0; i < 4; i++ {
+		<-exec.events
+	}
+	exec.flushOverflow()
+	if len(exec.events) != 16 || len(exec.overflow) != 0 {
+		t.Errorf("unexpected events %d, overflow %d", len(exec.events), len(exec.overflow))
+	}
+}
+
+func TestBackfillCreateError(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	config := &Config{
+		Spec: &Spec{
+			Name:   "test",
+			Params: []ParamSpec{{Name: "shard", Required: true}},
+			Tasks:  []TaskSpec{{Name: "step1", JobTemplate: JobTemplate{Image: "step1", Instances: 1, Parallelism: 1}}},
+		},
+	}
+	defaultPipelineSpecValues(config.Spec, "../../templates")
+	pipeline := &Pipeline{Name: "test", State: StateStopped, Config: config}
+
+	b, err := pipeline.createBackfill(&BackfillRequest{Param: "shard", Values: []string{"1", "x"}, MaxRunning: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the parameter type changes after the backfill is created
+	config.Spec.Params[0].Type = "int"
+
+	exec.scheduleBackfill(pipeline, b)
+	if _, ok := b.Errors["x"]; !ok || len(exec.events) != 1 {
+		t.Fatalf("failure not recorded: %+v", b.Errors)
+	}
+	pipeline.getInstance(b.Instances["1"]).State = StateComplete
+	exec.scheduleBackfill(pipeline, b)
+	expected := BackfillProgress{Total: 2, Complete: 1, Failed: 1}
+	if b.Progress != expected || b.State != BackfillComplete {
+		t.Errorf("unexpected progress %+v %s", b.Progress, b.State)
+	}
+	if len(exec.events) != 1 {
+		t.Error("failed value retried")
+	}
+}
+
+func TestBackfillCancel(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	config := &Config{
+		Spec: &Spec{
+			Name:   "test",
+			Params: []ParamSpec{{Name: "shard", Required: true}},
+			Tasks:  []TaskSpec{{Name: "step1", JobTemplate: JobTemplate{Image: "step1", Instances: 1, Parallelism: 1}}},
+		},
+	}
+	defaultPipelineSpecValues(config.Spec, "../../templates")
+	pipeline := &Pipeline{Name: "test", State: StateStopped, Config: config}
+
+	b, err := pipeline.createBackfill(&BackfillRequest{Param: "shard", Values: []string{"1", "2"}, MaxRunning: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec.scheduleBackfill(pipeline, b)
+	for len(exec.events) > 0 {
+		<-exec.events
+	}
+	for _, id := range b.Instances {
+		pipeline.getInstance(id).State = StateRunning
+	}
+
+	// the state changes immediately; the instances are aborted by the
+	// executor goroutine
+	if err := exec.BackfillUpdate(pipeline, &BackfillControlRequest{ID: b.ID, Action: BackfillActionCancel}); err != nil {
+		t.Fatal(err)
+	}
+	if b.State != BackfillCancelled {
+		t.Errorf("unexpected state %s", b.State)
+	}
+	ev, ok := (<-exec.events).(*evBackfillCancel)
+	if !ok || len(exec.events) != 0 {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	exec.handleBackfillCancel(ev)
+	for i := 0; i < 2; i++ {
+		if _, ok := (<-exec.events).(*evTaskAbort); !ok {
+			t.Error("expected task abort")
+		}
+	}
+
+	if err := exec.BackfillUpdate(pipeline, &BackfillControlRequest{ID: b.ID, Action: BackfillActionCancel}); err == nil {
+		t.Error("expected error cancelling a cancelled backfill")
+	}
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// BulkAction defines the operations that can be applied to a set of instances.
+type BulkAction string
+
+const (
+	// BulkStop stops all active instances.
+	BulkStop BulkAction = "stop"
+	// BulkDelete deletes all instances that are not active.
+	BulkDelete BulkAction = "delete"
+	// BulkRestart restarts stopped instances at the stage where they stopped.
+	BulkRestart BulkAction = "restart"
+)
+
+// BulkRequest applies an action to every instance of a pipeline or of all
+// the pipelines whose name matches Pattern.
+type BulkRequest struct {
+	Action   BulkAction
+	Pipeline string `json:",omitempty"`
+	Pattern  string `json:",omitempty"`
+}
+
+// BulkResult is the outcome of a bulk operation on a single instance.
+type BulkResult struct {
+	Pipeline string
+	ID       int
+	Error    string `json:",omitempty"`
+}
+
+func (exec *mrExecutor) bulkPipelines(request *BulkRequest) ([]*Pipeline, error) {
+	if request.Pipeline != "" {
+		p := exec.PipelineLookup(request.Pipeline)
+		if p == nil {
+			return nil, fmt.Errorf("Unknown pipeline %s", request.Pipeline)
+		}
+		return []*Pipeline{p}, nil
+	}
+	if request.Pattern == "" {
+		return nil, fmt.Errorf("Either pipeline or pattern must be specified")
+	}
+	re, err := regexp.Compile(request.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	keys := exec.PipelineMapKeys(re)
+	sort.Strings(keys)
+	var pipelines []*Pipeline
+	for _, k := range keys {
+		if p := exec.PipelineLookup(k); p != nil {
+			pipelines = append(pipelines, p)
+		}
+	}
+	return pipelines, nil
+}
+
+// BulkUpdate applies the request action to the selected instances and returns
+// a result per instance.
+func (exec *mrExecutor) BulkUpdate(request *BulkRequest) ([]*BulkResult, error) {
+	switch request.Action {
+	case BulkStop, BulkDelete, BulkRestart:
+	default:
+		return nil, fmt.Errorf("Invalid action %s", request.Action)
+	}
+
+	pipelines, err := exec.bulkPipelines(request)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*BulkResult{}
+	for _, p := range pipelines {
+		for _, instance := range p.Instances {
+			result := &BulkResult{Pipeline: p.Name, ID: instance.ID}
+			var err error
+			switch request.Action {
+			case BulkStop:
+				if !instance.isActive() {
+					continue
+				}
+				err = exec.SetState(p, &StateRequest{Action: ActionStop, ID: instance.ID})
+			case BulkDelete:
+				if instance.isActive() {
+					err = fmt.Errorf("Instance is %s", instance.State)
+				} else if instance.isCloning() {
+					err = fmt.Errorf("Instance %d is being cloned", instance.ID)
+				} else {
+					exec.DeleteInstance(p, instance.ID)
+				}
+			case BulkRestart:
+				if instance.State != StateStopped {
+					continue
+				}
+				err = exec.SetState(p, &StateRequest{Action: ActionStart, ID: instance.ID, Stage: instance.Stage})
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
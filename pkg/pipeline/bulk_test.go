@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"testing"
+)
+
+func TestBulkUpdate(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	for _, name := range []string{"daily-a", "daily-b", "weekly"} {
+		exec.pipelines[name] = &Pipeline{
+			Name: name,
+			Instances: []*Instance{
+				{ID: 1, State: StateComplete},
+				{ID: 2, State: StateRunning},
+				{ID: 3, State: StateStopped, Stage: 1},
+				{ID: 4, Clone: &CloneStatus{State: CloneCopying}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		request BulkRequest
+		results int
+		errors  int
+	}{
+		{BulkRequest{Action: BulkStop, Pattern: "^daily-"}, 2, 0},
+		{BulkRequest{Action: BulkRestart, Pipeline: "weekly"}, 1, 0},
+		{BulkRequest{Action: BulkDelete, Pattern: "."}, 12, 6},
+	}
+
+	for _, tc := range testCases {
+		results, err := exec.BulkUpdate(&tc.request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != tc.results {
+			t.Errorf("%s: expected %d results, got %d", tc.request.Action, tc.results, len(results))
+		}
+		var errors int
+		for _, r := range results {
+			if r.Error != "" {
+				errors++
+			}
+		}
+		if errors != tc.errors {
+			t.Errorf("%s: expected %d errors, got %d", tc.request.Action, tc.errors, errors)
+		}
+		for len(exec.events) > 0 {
+			<-exec.events
+		}
+	}
+
+	// a delete request sent before the clone started is ignored
+	p := exec.pipelines["weekly"]
+	exec.handleInstanceDelete(&evInstanceDelete{p, 4})
+	if p.getInstance(4) == nil {
+		t.Error("instance deleted while it is being cloned")
+	}
+
+	if _, err := exec.BulkUpdate(&BulkRequest{Action: BulkStop}); err == nil {
+		t.Error("expected error for request without pipeline or pattern")
+	}
+}
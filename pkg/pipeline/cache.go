@@ -0,0 +1,233 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	batch_v1 "k8s.io/client-go/pkg/apis/batch/v1"
+)
+
+// taskPaths expands the input or output paths of a task. Relative paths are
+// resolved against the instance work directory.
+func taskPaths(spec *Spec, instance *Instance, taskSpec *TaskSpec, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	vars := makeTemplateVars(spec, instance, taskSpec, &taskSpec.JobTemplate)
+	expanded, err := expandTemplateArgs(vars, paths)
+	if err != nil {
+		return nil, err
+	}
+	for i, path := range expanded {
+		if !strings.Contains(path, "://") {
+			expanded[i] = pathJoin(vars.Pipeline["WorkDir"], path)
+		}
+	}
+	return expanded, nil
+}
+
+// relativePath replaces the work directory prefix of a path, so that the
+// cache key of a task does not depend on the instance that executes it.
+func relativePath(workDir, path string) string {
+	if workDir != "" {
+		return strings.Replace(path, strings.TrimSuffix(workDir, "/")+"/", "$WORKDIR/", -1)
+	}
+	return path
+}
+
+// writeJobKey writes the parts of the rendered jobs that determine the task
+// outputs: the container images, commands, arguments, environment and
+// resources.
+func writeJobKey(w io.Writer, jobs []*batch_v1.Job, workDir string) {
+	for _, job := range jobs {
+		for _, c := range job.Spec.Template.Spec.Containers {
+			fmt.Fprintf(w, "image %s\n", c.Image)
+			for _, arg := range c.Command {
+				fmt.Fprintf(w, "command %s\n", relativePath(workDir, arg))
+			}
+			for _, arg := range c.Args {
+				fmt.Fprintf(w, "arg %s\n", relativePath(workDir, arg))
+			}
+			for _, env := range c.Env {
+				fmt.Fprintf(w, "env %s=%s\n", env.Name, relativePath(workDir, env.Value))
+			}
+			for _, name := range sortedResourceNames(c.Resources.Requests) {
+				q := c.Resources.Requests[name]
+				fmt.Fprintf(w, "request %s=%s\n", name, q.String())
+			}
+			for _, name := range sortedResourceNames(c.Resources.Limits) {
+				q := c.Resources.Limits[name]
+				fmt.Fprintf(w, "limit %s=%s\n", name, q.String())
+			}
+		}
+	}
+}
+
+// writeInputDigest writes the name, size and checksum of the objects of an
+// input.
+func writeInputDigest(ctx context.Context, w io.Writer, input, workDir string) error {
+	objects, err := resolvePath(ctx, input, workDir)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		fmt.Fprintf(w, "missing %s\n", relativePath(workDir, input))
+		return nil
+	}
+	for i := range objects {
+		checksum, err := objectChecksum(ctx, &objects[i])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "input %s %d %s\n", relativePath(workDir, objects[i].URI), objects[i].Size, checksum)
+	}
+	return nil
+}
+
+// taskCacheKey computes the cache key of a task from its rendered jobs and
+// the content of its inputs.
+func taskCacheKey(jobs []*batch_v1.Job, workDir string, inputs []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	h := sha256.New()
+	writeJobKey(h, jobs, workDir)
+	for _, input := range inputs {
+		if err := writeInputDigest(ctx, h, input, workDir); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// findCachedTask returns the most recent instance, and the stage within it,
+// that completed the named task with the specified cache key.
+func (p *Pipeline) findCachedTask(target *Instance, name, key string) (*Instance, int) {
+	for i := len(p.Instances) - 1; i >= 0; i-- {
+		instance := p.Instances[i]
+		if instance == target {
+			continue
+		}
+		tasks := p.instanceSpec(instance).Tasks
+		for stage, task := range instance.TaskList {
+			if task.CacheKey == key && stage < len(tasks) && tasks[stage].Name == name {
+				return instance, stage
+			}
+		}
+	}
+	return nil, 0
+}
+
+// copyOutputs copies the objects that match an output of the task in the
+// src work directory to the dst work directory.
+func copyOutputs(src, dst, output string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	src = strings.TrimSuffix(src, "/") + "/"
+	objects, err := resolvePath(ctx, src+output, src)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := copyObject(ctx, obj.URI, pathJoin(dst, obj.URI[len(src):])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startCacheLookup computes the cache key of a task in the background. The
+// task is either satisfied from the cache or its jobs are started when the
+// key is known.
+func (exec *mrExecutor) startCacheLookup(p *Pipeline, instance *Instance, stage int) {
+	spec := p.instanceSpec(instance)
+	inputs, err := taskPaths(spec, instance, &spec.Tasks[stage], spec.Tasks[stage].Inputs)
+	if err != nil {
+		exec.post(&evTaskAbort{p, instance.ID, stage, err.Error(), time.Now()})
+		return
+	}
+	jobs := instance.TaskList[stage].jobs
+	workDir := p.instanceWorkDir(instance)
+	go func() {
+		key, err := taskCacheKey(jobs, workDir, inputs)
+		exec.events <- &evTaskCacheKey{p, instance.ID, stage, key, err}
+	}()
+}
+
+type evTaskCacheKey struct {
+	pipeline   *Pipeline
+	instanceID int
+	taskIndex  int
+	key        string
+	err        error
+}
+
+func (ev *evTaskCacheKey) eventType() smEventType { return eventTaskCacheKey }
+func (ev *evTaskCacheKey) String() string {
+	return fmt.Sprintf("TASK CACHE KEY %s:%d task:%d %s", ev.pipeline.Name, ev.instanceID, ev.taskIndex, ev.key)
+}
+
+func (exec *mrExecutor) handleTaskCacheKey(event *evTaskCacheKey) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil || instance.Stage != event.taskIndex {
+		return
+	}
+	if instance.State == StatePaused {
+		// the lookup completes when the instance is resumed
+		instance.TaskList[event.taskIndex].deferred = event
+		return
+	}
+	if instance.State != StateRunning {
+		return
+	}
+	spec := p.instanceSpec(instance)
+	taskSpec := &spec.Tasks[event.taskIndex]
+	task := instance.TaskList[event.taskIndex]
+
+	if event.err != nil {
+		// the task executes when the cache cannot be checked
+		log.Printf("%s:%d task %s cache: %v", p.Name, instance.ID, taskSpec.Name, event.err)
+		exec.startJobs(p, instance, event.taskIndex)
+		return
+	}
+	task.cacheKey = event.key
+
+	src, srcStage := p.findCachedTask(instance, taskSpec.Name, event.key)
+	if src == nil {
+		exec.startJobs(p, instance, event.taskIndex)
+		return
+	}
+	outputs, err := taskPaths(spec, instance, taskSpec, taskSpec.Outputs)
+	if err != nil {
+		exec.post(&evTaskAbort{p, instance.ID, event.taskIndex, err.Error(), time.Now()})
+		return
+	}
+	srcDir := p.instanceWorkDir(src)
+	workDir := p.instanceWorkDir(instance)
+	task.CachedFrom = &InstanceRef{Pipeline: p.Name, ID: src.ID, Stage: srcStage, WorkDir: srcDir}
+	log.Printf("%s:%d task %s: reusing the outputs of instance %d", p.Name, instance.ID, taskSpec.Name, src.ID)
+
+	go func() {
+		prefix := strings.TrimSuffix(workDir, "/") + "/"
+		for _, output := range outputs {
+			// outputs outside of the work directory are shared
+			if !strings.HasPrefix(output, prefix) {
+				continue
+			}
+			if err := copyOutputs(srcDir, workDir, output[len(prefix):]); err != nil {
+				msg := fmt.Sprintf("cache copy from instance %d: %v", src.ID, err)
+				exec.events <- &evTaskAbort{p, instance.ID, event.taskIndex, msg, time.Now()}
+				return
+			}
+		}
+		exec.events <- &evTaskComplete{p, instance.ID, event.taskIndex, time.Now()}
+	}()
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+	batch_v1 "k8s.io/client-go/pkg/apis/batch/v1"
+)
+
+func makeCacheTestJob(image, workDir string) *batch_v1.Job {
+	job := &batch_v1.Job{}
+	job.Spec.Template.Spec.Containers = []api_v1.Container{
+		{Image: image, Args: []string{"--input=" + workDir + "/in", "--output=" + workDir + "/out"}},
+	}
+	return job
+}
+
+func TestTaskCacheKey(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	writeObject(t, "mem://cache/1/in/part-0", "0")
+	writeObject(t, "mem://cache/1/in/part-1", "1")
+	writeObject(t, "mem://cache/2/in/part-0", "0")
+	writeObject(t, "mem://cache/2/in/part-1", "1")
+
+	key1, err := taskCacheKey([]*batch_v1.Job{makeCacheTestJob("build:1", "mem://cache/1")}, "mem://cache/1", []string{"mem://cache/1/in/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := taskCacheKey([]*batch_v1.Job{makeCacheTestJob("build:1", "mem://cache/2")}, "mem://cache/2", []string{"mem://cache/2/in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key1 != key2 {
+		t.Error("the cache key depends on the instance work dir")
+	}
+
+	key3, _ := taskCacheKey([]*batch_v1.Job{makeCacheTestJob("build:2", "mem://cache/2")}, "mem://cache/2", []string{"mem://cache/2/in/"})
+	if key3 == key1 {
+		t.Error("the cache key does not depend on the image")
+	}
+	writeObject(t, "mem://cache/2/in/part-1", "2")
+	key4, _ := taskCacheKey([]*batch_v1.Job{makeCacheTestJob("build:1", "mem://cache/2")}, "mem://cache/2", []string{"mem://cache/2/in/"})
+	if key4 == key1 {
+		t.Error("the cache key does not depend on the input content")
+	}
+}
+
+func TestTaskCacheHit(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:    "test",
+			Storage: "mem://cache",
+			Tasks: []TaskSpec{
+				{Name: "build", Cache: true, Outputs: []string{"out/", "mem://shared/out"}},
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateComplete, TaskList: []*Task{{CacheKey: "abc"}}},
+			{ID: 2, State: StateRunning, TaskList: []*Task{{}}},
+		},
+	}
+	writeObject(t, "mem://cache/1/out/part-0", "0")
+	writeObject(t, "mem://cache/1/out/part-1", "1")
+
+	exec.handleTaskCacheKey(&evTaskCacheKey{p, 2, 0, "abc", nil})
+
+	var ev smEvent
+	select {
+	case ev = <-exec.events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached task did not complete")
+	}
+	complete, ok := ev.(*evTaskComplete)
+	if !ok {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+	if ref := p.Instances[1].TaskList[0].CachedFrom; ref == nil || ref.ID != 1 {
+		t.Errorf("unexpected cache source %+v", ref)
+	}
+	files, err := listFiles("mem://cache/2/out/")
+	if err != nil || len(files) != 2 {
+		t.Errorf("outputs not copied: %v %v", files, err)
+	}
+
+	writeObject(t, "mem://shared/out", "shared")
+	exec.handleTaskComplete(complete)
+	exec.handleTaskOutputs((<-exec.events).(*evTaskOutputs))
+	if outputs := p.Instances[1].Outputs; len(outputs) != 3 || outputs[0].CachedFrom == nil {
+		t.Errorf("unexpected outputs %+v", outputs)
+	}
+	exec.handleTaskComplete((<-exec.events).(*evTaskComplete))
+	if key := p.Instances[1].TaskList[0].CacheKey; key != "abc" {
+		t.Errorf("cache key not recorded: %q", key)
+	}
+}
+
+func TestTaskCacheKeyPaused(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:    "test",
+			Storage: "mem://cache",
+			Tasks: []TaskSpec{
+				{Name: "build", Cache: true, Outputs: []string{"out/"}},
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, TaskList: []*Task{{}}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+	instance := p.Instances[0]
+
+	// the instance is paused while the key is computed
+	exec.handleInstancePause(&evInstancePause{p, 1, false})
+	exec.handleTaskCacheKey(&evTaskCacheKey{p, 1, 0, "abc", nil})
+	if instance.State != StatePaused || instance.TaskList[0].cacheKey != "" {
+		t.Fatalf("cache key processed while paused: %s", instance.State)
+	}
+
+	exec.handleInstancePause(&evInstancePause{p, 1, true})
+	ev := nextEvent(t, exec)
+	pending, ok := ev.(*evTaskCacheKey)
+	if !ok || pending.key != "abc" {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+	if instance.TaskList[0].deferred != nil {
+		t.Error("pending cache key not cleared")
+	}
+	exec.handleTaskCacheKey(pending)
+	if instance.TaskList[0].cacheKey != "abc" {
+		t.Errorf("cache key not recorded after resume")
+	}
+}
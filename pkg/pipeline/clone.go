@@ -0,0 +1,173 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// maxCloneErrors limits the number of copy errors recorded in a CloneStatus.
+const maxCloneErrors = 10
+
+// CloneState defines the progress of the copy of a work directory.
+type CloneState string
+
+const (
+	// CloneCopying means that the objects are being copied.
+	CloneCopying CloneState = "Copying"
+	// CloneComplete means that all the objects were copied.
+	CloneComplete CloneState = "Complete"
+	// CloneFailed means that the listing or the copy of some objects failed.
+	CloneFailed CloneState = "Failed"
+)
+
+// CloneStatus reports the copy of the work directory of a cloned instance.
+type CloneStatus struct {
+	// Source is the instance that was cloned.
+	Source  InstanceRef
+	Include string `json:",omitempty"`
+	Exclude string `json:",omitempty"`
+	// Start is the stage the instance is started at when the copy completes.
+	Start *int `json:",omitempty"`
+
+	State CloneState
+	// Total is the number of objects to copy.
+	Total  int
+	Copied int
+	Failed int
+	// Errors contains the first copy errors.
+	Errors []string `json:",omitempty"`
+}
+
+// isCloning returns true while the work directory of the instance is being
+// copied.
+func (instance *Instance) isCloning() bool {
+	return instance.Clone != nil && instance.Clone.State == CloneCopying
+}
+
+// restoreClones marks the copies that were interrupted by a restart as failed.
+func (p *Pipeline) restoreClones() {
+	for _, instance := range p.Instances {
+		if instance.isCloning() {
+			instance.Clone.State = CloneFailed
+			instance.Clone.Errors = append(instance.Clone.Errors, "interrupted by a restart")
+		}
+	}
+}
+
+// Clone creates an instance and copies the work directory of an existing
+// instance into it. The copy executes in the background; its progress is
+// reported in the Clone status of the new instance.
+func (exec *mrExecutor) Clone(p *Pipeline, request *CloneRequest) (*Instance, error) {
+	var reIncl, reExcl *regexp.Regexp
+	if request.Include != "" {
+		var err error
+		if reIncl, err = regexp.Compile(request.Include); err != nil {
+			return nil, err
+		}
+	}
+	if request.Exclude != "" {
+		var err error
+		if reExcl, err = regexp.Compile(request.Exclude); err != nil {
+			return nil, err
+		}
+	}
+	if p.Config.Spec.Storage == "" {
+		return nil, fmt.Errorf("Pipeline %s has no storage", p.Name)
+	}
+	if start := request.Start; start != nil && (*start < 0 || *start >= len(p.Config.Spec.Tasks)) {
+		return nil, fmt.Errorf("Invalid stage %d", *start)
+	}
+
+	opts := &instanceOptions{}
+	prevDir := pathJoin(p.Config.Spec.Storage, strconv.Itoa(request.Instance))
+	if prev := p.getInstance(request.Instance); prev != nil {
+		prevDir = p.instanceWorkDir(prev)
+		opts.params = prev.Params
+	}
+	exec.Lock()
+	defer exec.Unlock()
+	instance, err := p.createInstance(opts)
+	if err != nil {
+		return nil, err
+	}
+	instance.Clone = &CloneStatus{
+		Source: InstanceRef{
+			Pipeline: p.Name,
+			ID:       request.Instance,
+			WorkDir:  prevDir,
+		},
+		Include: request.Include,
+		Exclude: request.Exclude,
+		Start:   request.Start,
+		State:   CloneCopying,
+	}
+	go exec.cloneWorkDir(p, instance, prevDir, reIncl, reExcl)
+	return instance, nil
+}
+
+// cloneWorkDir copies the objects of a work directory to the work directory
+// of a cloned instance, updating its clone status.
+func (exec *mrExecutor) cloneWorkDir(p *Pipeline, instance *Instance, src string, reIncl, reExcl *regexp.Regexp) {
+	status := instance.Clone
+	names, err := workDirObjects(src, reIncl, reExcl)
+	if err != nil {
+		exec.events <- &evCloneComplete{p, instance.ID, err}
+		return
+	}
+	exec.Lock()
+	status.Total = len(names)
+	exec.Unlock()
+
+	copyObjects(src, p.instanceWorkDir(instance), names, func(name string, err error) {
+		exec.Lock()
+		defer exec.Unlock()
+		if err != nil {
+			log.Printf("%s:%d clone %s: %v", p.Name, instance.ID, name, err)
+			status.Failed++
+			if len(status.Errors) < maxCloneErrors {
+				status.Errors = append(status.Errors, err.Error())
+			}
+			return
+		}
+		status.Copied++
+	})
+	exec.events <- &evCloneComplete{p, instance.ID, nil}
+}
+
+type evCloneComplete struct {
+	pipeline   *Pipeline
+	instanceID int
+	err        error
+}
+
+func (ev *evCloneComplete) eventType() smEventType { return eventCloneComplete }
+func (ev *evCloneComplete) String() string {
+	return fmt.Sprintf("CLONE COMPLETE %s:%d", ev.pipeline.Name, ev.instanceID)
+}
+
+func (exec *mrExecutor) handleCloneComplete(event *evCloneComplete) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil || !instance.isCloning() {
+		return
+	}
+	status := instance.Clone
+
+	exec.Lock()
+	switch {
+	case event.err != nil:
+		status.State = CloneFailed
+		status.Errors = append(status.Errors, event.err.Error())
+	case status.Failed > 0:
+		status.State = CloneFailed
+	default:
+		status.State = CloneComplete
+	}
+	exec.Unlock()
+
+	if status.State == CloneComplete && status.Start != nil {
+		exec.post(&evPipelineRun{p, instance.ID, *status.Start})
+	}
+}
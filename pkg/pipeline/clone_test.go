@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloneAsync(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:    "test",
+			Storage: "mem://clone",
+			Params:  []ParamSpec{{Name: "date"}},
+			Tasks: []TaskSpec{
+				{Name: "first", Approval: &ApprovalSpec{}},
+				{Name: "second", Approval: &ApprovalSpec{}},
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateComplete, Params: map[string]string{"date": "2017-06-01"}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+	writeObject(t, "mem://clone/1/first/part-0", "0")
+	writeObject(t, "mem://clone/1/first/part-1", "1")
+	writeObject(t, "mem://clone/1/second/part-0", "2")
+
+	start := 5
+	if _, err := exec.Clone(p, &CloneRequest{Pipeline: "test", Instance: 1, Start: &start}); err == nil {
+		t.Error("expected an invalid stage error")
+	}
+
+	start = 1
+	instance, err := exec.Clone(p, &CloneRequest{Pipeline: "test", Instance: 1, Include: "^first/", Start: &start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.ID != 2 || instance.Params["date"] != "2017-06-01" {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	if err := exec.SetState(p, &StateRequest{Action: ActionStart, ID: instance.ID}); err == nil {
+		t.Error("instance started while cloning")
+	}
+
+	var ev smEvent
+	select {
+	case ev = <-exec.events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("clone did not complete")
+	}
+	complete, ok := ev.(*evCloneComplete)
+	if !ok {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+	exec.handleCloneComplete(complete)
+
+	status := instance.Clone
+	if status.State != CloneComplete || status.Total != 2 || status.Copied != 2 || status.Failed != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	files, err := listFiles("mem://clone/2/")
+	if err != nil || len(files) != 2 {
+		t.Errorf("unexpected files %v %v", files, err)
+	}
+
+	ev = <-exec.events
+	if run, ok := ev.(*evPipelineRun); !ok || run.instanceID != 2 || run.taskIndex != 1 {
+		t.Errorf("unexpected event %s", ev.String())
+	}
+}
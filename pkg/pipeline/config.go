@@ -8,6 +8,7 @@ import (
 	"log"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"bytes"
 
@@ -17,7 +18,7 @@ import (
 
 // Config contains a pipeline configuration
 type Config struct {
-	Hash []byte `json:"md5_hash"` // md5 hash of the configuration file
+	Hash []byte `json:"md5_hash"` // sha256 hash of the configuration file
 	Spec *Spec  `json:"spec"`     // parsed configuration
 }
 
@@ -33,9 +34,41 @@ type Spec struct {
 	// Schedule defines a crontab style schedule.
 	Schedule *CronSchedule `json:",omitempty"`
 
+	// Params declares the run parameters of the pipeline instances.
+	Params []ParamSpec `json:",omitempty"`
+
+	// Triggers start an instance of this pipeline when an upstream pipeline
+	// instance (or one of its tasks) completes.
+	Triggers []TriggerSpec `json:",omitempty"`
+
+	// MaxConcurrentInstances limits the number of instances of the pipeline
+	// that execute at the same time (0 means no limit).
+	MaxConcurrentInstances int `json:",omitempty"`
+
+	// Priority orders the queued instances; higher values start first.
+	Priority int `json:",omitempty"`
+
+	// Budget limits the resources requested by the running tasks of the
+	// pipeline, in addition to the namespace resource quota. A task that
+	// requests more than the budget on its own is aborted.
+	Budget api.ResourceList `json:",omitempty"`
+
+	// Retention defines when finished instances are deleted. Instances are
+	// kept until deleted through the API when not set.
+	Retention *RetentionSpec `json:",omitempty"`
+
 	Tasks []TaskSpec
 }
 
+// TriggerSpec defines an upstream event that starts the pipeline.
+type TriggerSpec struct {
+	// Pipeline is the name of the upstream pipeline.
+	Pipeline string
+	// Task, when set, fires the trigger when the named task completes rather
+	// than when the upstream instance completes.
+	Task string `json:",omitempty"`
+}
+
 // TaskSpec defines the tasks to execute for this pipeline.
 type TaskSpec struct {
 	// Task name
@@ -43,6 +76,33 @@ type TaskSpec struct {
 
 	EtcdLock string `json:"etcd_lock"`
 
+	// Sensor defines a task that waits for data to be present in storage
+	// rather than executing a k8s job.
+	Sensor *SensorSpec `json:"sensor,omitempty"`
+
+	// Map executes one job per input, with the inputs listed at run time.
+	Map *MapSpec `json:"map,omitempty"`
+
+	// Pipeline executes an instance of another pipeline as the task.
+	Pipeline *SubPipelineSpec `json:"pipeline,omitempty"`
+
+	// Approval pauses the instance until the task is approved or rejected.
+	Approval *ApprovalSpec `json:"approval,omitempty"`
+
+	// Inputs and Outputs are the storage locations the task reads and
+	// writes. Relative paths are resolved against the instance work
+	// directory and template variables are expanded. Paths are glob
+	// patterns, where "**" matches any number of directories; paths that
+	// end in "/" are prefixes. Each output must match at least one object
+	// when the task completes.
+	Inputs  []string `json:"inputs,omitempty"`
+	Outputs []string `json:"outputs,omitempty"`
+
+	// Cache reuses the outputs of an earlier instance, rather than running
+	// the jobs, when the rendered jobs and the task inputs are unchanged.
+	// Only the declared outputs are copied.
+	Cache bool `json:"cache,omitempty"`
+
 	Services     []ServiceSpec  `json:"services"`
 	TemplateList []*JobTemplate `json:"jobs,omitempty"`
 	JobTemplate  `json:",inline"`
@@ -50,12 +110,65 @@ type TaskSpec struct {
 
 // JobSpecs returns the jobs for a taskSpec.
 func (s *TaskSpec) JobSpecs() []*JobTemplate {
+	if !s.hasJobs() {
+		return nil
+	}
 	if len(s.TemplateList) == 0 {
 		return []*JobTemplate{&s.JobTemplate}
 	}
 	return s.TemplateList
 }
 
+// hasJobs returns true if the task is executed as a set of k8s jobs.
+func (s *TaskSpec) hasJobs() bool {
+	return s.Sensor == nil && s.Pipeline == nil && s.Approval == nil
+}
+
+// ApprovalSpec defines a manual approval task.
+type ApprovalSpec struct {
+	// Timeout after which the instance is aborted (defaults to no timeout).
+	Timeout string `json:",omitempty"`
+}
+
+// SubPipelineSpec defines a task that executes an instance of another pipeline.
+type SubPipelineSpec struct {
+	// Name of the pipeline.
+	Name string
+	// URI of the pipeline configuration. It is used to register the pipeline
+	// when no pipeline with the specified name exists.
+	URI string `json:",omitempty"`
+	// Params of the child instance. Template variables are expanded.
+	Params map[string]string `json:",omitempty"`
+}
+
+// MapSpec defines the inputs of a task whose fan-out is computed when the task
+// executes. Exactly one of Prefix or Manifest must be specified.
+type MapSpec struct {
+	// Prefix is a storage prefix; each object under it is a shard input.
+	// Template variables are expanded.
+	Prefix string `json:",omitempty"`
+	// Manifest is a file containing one shard input per line. Relative paths
+	// are interpreted relative to the instance work dir.
+	Manifest string `json:",omitempty"`
+	// MaxShards limits the number of shards (defaults to 1000).
+	MaxShards int `json:",omitempty"`
+}
+
+// SensorSpec defines a task that polls a storage location until it exists.
+type SensorSpec struct {
+	// URI of the file or prefix to check (e.g. gs://bucket/export/). Template
+	// variables are expanded.
+	URI string
+	// Prefix waits for any object under URI rather than URI itself.
+	Prefix bool `json:",omitempty"`
+	// Marker waits for the named file under URI (e.g. _SUCCESS).
+	Marker string `json:",omitempty"`
+	// Interval between checks (defaults to 1m).
+	Interval string `json:",omitempty"`
+	// Timeout after which the task is aborted (defaults to no timeout).
+	Timeout string `json:",omitempty"`
+}
+
 // ServiceSpec defines the specification for a service.
 type ServiceSpec struct {
 	// Service is the name of the service
@@ -116,6 +229,9 @@ func defaultServiceValues(task *TaskSpec, dataDir string) {
 func defaultPipelineSpecValues(spec *Spec, dataDir string) {
 	for i := range spec.Tasks {
 		task := &spec.Tasks[i]
+		if !task.hasJobs() {
+			continue
+		}
 		if len(task.TemplateList) == 0 {
 			defaultJobTemplateValues(&task.JobTemplate, dataDir)
 		} else {
@@ -179,16 +295,130 @@ func validateTaskService(task *TaskSpec, svc *ServiceSpec) error {
 	return nil
 }
 
+func validateSensor(sensor *SensorSpec) error {
+	if sensor.URI == "" {
+		return &validationError{"sensor uri must be specified"}
+	}
+	for _, v := range []string{sensor.Interval, sensor.Timeout} {
+		if v == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(v); err != nil {
+			return &validationError{fmt.Sprintf("invalid sensor duration %s", v)}
+		}
+	}
+	return nil
+}
+
+func validateMapTask(task *TaskSpec) error {
+	spec := task.Map
+	if (spec.Prefix == "") == (spec.Manifest == "") {
+		return &validationError{fmt.Sprintf("map task %s must specify one of prefix or manifest", task.Name)}
+	}
+	if len(task.TemplateList) > 0 || len(task.Services) > 0 {
+		return &validationError{fmt.Sprintf("map task %s cannot define a job list or services", task.Name)}
+	}
+	if spec.MaxShards < 0 {
+		return &validationError{"invalid number of shards"}
+	}
+	return nil
+}
+
+func validateTaskType(task *TaskSpec) error {
+	if task.hasJobs() {
+		return nil
+	}
+	if !isJobTemplateEmpty(&task.JobTemplate) || len(task.TemplateList) > 0 || len(task.Services) > 0 || task.Map != nil {
+		return &validationError{fmt.Sprintf("task %s cannot define jobs or services", task.Name)}
+	}
+	if task.Cache {
+		return &validationError{fmt.Sprintf("task %s: only job tasks can be cached", task.Name)}
+	}
+	var count int
+	for _, set := range []bool{task.Sensor != nil, task.Pipeline != nil, task.Approval != nil} {
+		if set {
+			count++
+		}
+	}
+	if count > 1 {
+		return &validationError{fmt.Sprintf("task %s: sensor, pipeline and approval are mutually exclusive", task.Name)}
+	}
+	switch {
+	case task.Sensor != nil:
+		return validateSensor(task.Sensor)
+	case task.Pipeline != nil:
+		if task.Pipeline.Name == "" {
+			return &validationError{fmt.Sprintf("task %s: pipeline name must be specified", task.Name)}
+		}
+	case task.Approval != nil:
+		if task.Approval.Timeout != "" {
+			if _, err := time.ParseDuration(task.Approval.Timeout); err != nil {
+				return &validationError{fmt.Sprintf("invalid approval timeout %s", task.Approval.Timeout)}
+			}
+		}
+	}
+	return nil
+}
+
 func validatePipelineConfig(spec *Spec) error {
 	if spec.Name == "" {
 		return &validationError{"pipeline name must be specified"}
 	}
-	if spec.Storage != "" && !strings.HasPrefix(spec.Storage, "gs://") {
-		return &validationError{"unsupported storage method"}
+	if spec.Storage != "" {
+		backend, err := storageFor(spec.Storage)
+		if err != nil {
+			return &validationError{"unsupported storage method"}
+		}
+		if !isWritable(backend) {
+			return &validationError{"storage must support writes"}
+		}
+	}
+	if err := validateParamSpecs(spec.Params); err != nil {
+		return err
+	}
+	if spec.MaxConcurrentInstances < 0 {
+		return &validationError{"maxConcurrentInstances must not be negative"}
+	}
+	if spec.Retention != nil {
+		if err := validateRetention(spec.Retention); err != nil {
+			return err
+		}
+	}
+	for i := range spec.Triggers {
+		if spec.Triggers[i].Pipeline == "" {
+			return &validationError{"trigger pipeline must be specified"}
+		}
+		if spec.Triggers[i].Pipeline == spec.Name {
+			return &validationError{"pipeline cannot be triggered by itself"}
+		}
 	}
 
 	for i := range spec.Tasks {
 		task := &spec.Tasks[i]
+		for _, patterns := range [][]string{task.Inputs, task.Outputs} {
+			for _, pattern := range patterns {
+				if err := validateGlob(pattern); err != nil {
+					return &validationError{fmt.Sprintf("task %s: %v", task.Name, err)}
+				}
+			}
+		}
+		if !task.hasJobs() {
+			if err := validateTaskType(task); err != nil {
+				return err
+			}
+			continue
+		}
+		if task.Map != nil {
+			if err := validateMapTask(task); err != nil {
+				return err
+			}
+		}
+		if task.Cache && spec.Storage == "" {
+			return &validationError{fmt.Sprintf("task %s: cache requires pipeline storage", task.Name)}
+		}
+		if task.Cache && len(task.Outputs) == 0 {
+			return &validationError{fmt.Sprintf("task %s: cache requires outputs", task.Name)}
+		}
 		if len(task.TemplateList) == 0 {
 			if err := validateJobTemplate(&task.JobTemplate); err != nil {
 				return err
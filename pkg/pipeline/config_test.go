@@ -83,6 +83,27 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			"testdata/trigger.yaml",
+			Spec{
+				Name:      "cofilter",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/cofilter",
+				Triggers:  []TriggerSpec{{Pipeline: "mr_sitedata"}},
+				Tasks: []TaskSpec{
+					{
+						Name: "compute",
+						JobTemplate: JobTemplate{
+							Template:    "file:///default-job-template.yaml",
+							Image:       "gcr.io/laserlike-1167/roque-mr_cofilter_compute",
+							Instances:   1,
+							Parallelism: 1,
+							Args:        []string{"-input={{.Trigger.WorkDir}}"},
+						},
+					},
+				},
+			},
+		},
 	}
 	for i := range testCases {
 		test := &testCases[i]
@@ -101,3 +122,40 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestStorageValidation(t *testing.T) {
+	testCases := []struct {
+		storage string
+		valid   bool
+	}{
+		{"gs://bucket/pipeline", true},
+		{"file:///data/pipeline", true},
+		{"https://example.com/pipeline", false},
+		{"git://example.com/repo@master:pipeline", false},
+		{"unknown://pipeline", false},
+	}
+	for _, test := range testCases {
+		err := validatePipelineConfig(&Spec{Name: "example", Storage: test.storage})
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected result %v", test.storage, err)
+		}
+	}
+}
+
+func TestCacheValidation(t *testing.T) {
+	testCases := []struct {
+		task  TaskSpec
+		valid bool
+	}{
+		{TaskSpec{Name: "build", Cache: true, Outputs: []string{"out/"}}, true},
+		{TaskSpec{Name: "build", Cache: true}, false},
+		{TaskSpec{Name: "build"}, true},
+	}
+	for _, test := range testCases {
+		test.task.JobTemplate = JobTemplate{Image: "build"}
+		spec := &Spec{Name: "example", Storage: "gs://bucket/example", Tasks: []TaskSpec{test.task}}
+		if err := validatePipelineConfig(spec); (err == nil) != test.valid {
+			t.Errorf("%+v: unexpected result %v", test.task, err)
+		}
+	}
+}
@@ -24,6 +24,10 @@ type CronSchedule struct {
 	Day     string
 	Month   string
 	Weekday string
+
+	// Params are the run parameters of the scheduled instances. Values are
+	// templates that can refer to the trigger time as {{.Time}}.
+	Params map[string]string `json:",omitempty"`
 }
 
 type schedule struct {
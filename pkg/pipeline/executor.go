@@ -3,9 +3,9 @@ package pipeline
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"regexp"
-	"strconv"
 	"sync"
 	"time"
 
@@ -16,14 +16,27 @@ import (
 // Executor is the interface for the executor class.
 type Executor interface {
 	PipelineAdd(name, uri string) error
-	SetState(p *Pipeline, action StateAction, instanceID int, stage int) error
-	Clone(p *Pipeline, id int, includePat, excludePat string) error
+	SetState(p *Pipeline, request *StateRequest) error
+	Clone(p *Pipeline, request *CloneRequest) (*Instance, error)
 	PipelineMapKeys(pattern *regexp.Regexp) []string
 	PipelineCount() int
 	PipelineLookup(name string) *Pipeline
-	PipelineReload(p *Pipeline) error
+	Marshal(v interface{}) ([]byte, error)
+	PipelineReload(p *Pipeline, user string) error
+	PipelineRollback(p *Pipeline, hash, user string) error
+	LookupConfig(p *Pipeline, hash string) (*Config, error)
 	PipelineDelete(p *Pipeline)
 	DeleteInstance(p *Pipeline, instanceID int)
+	BackfillCreate(p *Pipeline, request *BackfillRequest) (*Backfill, error)
+	BackfillUpdate(p *Pipeline, request *BackfillControlRequest) error
+	BulkUpdate(request *BulkRequest) ([]*BulkResult, error)
+	Queue() []QueueEntry
+	Lineage(uri string) []*LineageRecord
+	TaskPods(p *Pipeline, instance *Instance, stage int) []PodRecord
+	PodLogs(p *Pipeline, instance *Instance, pod string, follow bool) (io.ReadCloser, error)
+
+	SetMaxRunningInstances(n int)
+	SetPreemption(enabled bool)
 
 	Start()
 	Configure(uri string) error
@@ -38,6 +51,17 @@ type mrExecutor struct {
 	events         chan smEvent
 	cron           Cron
 	k8sClient      kubernetes.Interface
+
+	// maxRunning limits the number of running instances across pipelines.
+	maxRunning int
+	// preemption allows high priority instances to pause lower priority
+	// instances when maxRunning is reached.
+	preemption bool
+	// queue contains the instances waiting for an execution slot.
+	queue []QueueEntry
+	// overflow contains the events posted by the executor goroutine while
+	// the events channel is full.
+	overflow []smEvent
 }
 
 func (exec *mrExecutor) PipelineLookup(name string) *Pipeline {
@@ -46,6 +70,14 @@ func (exec *mrExecutor) PipelineLookup(name string) *Pipeline {
 	return exec.pipelines[name]
 }
 
+// Marshal encodes pipeline state as JSON while holding the lock, so that the
+// maps updated by the executor are not read concurrently.
+func (exec *mrExecutor) Marshal(v interface{}) ([]byte, error) {
+	exec.Lock()
+	defer exec.Unlock()
+	return json.Marshal(v)
+}
+
 // PipelineAdd executes from an http server goroutine.
 func (exec *mrExecutor) PipelineAdd(name, uri string) error {
 	// fetch the configuration from the storage service
@@ -62,23 +94,22 @@ func (exec *mrExecutor) PipelineAdd(name, uri string) error {
 	}
 
 	p := &Pipeline{
-		Name:   name,
-		URI:    uri,
-		State:  StateStopped,
-		Config: conf,
+		Name:  name,
+		URI:   uri,
+		State: StateStopped,
 	}
 	exec.Lock()
 	defer exec.Unlock()
 
-	exec.pipelines[name] = p
-	if p.Config.Spec.Schedule != nil {
-		t := &pipelineTrigger{exec, p}
-		exec.cron.Add(p.Name, p.Config.Spec.Schedule, t.trigger)
+	if err := exec.installConfig(p, conf, uri, ""); err != nil {
+		return err
 	}
+	exec.pipelines[name] = p
 	return nil
 }
 
-func (exec *mrExecutor) PipelineReload(p *Pipeline) error {
+// PipelineReload loads the current version of the pipeline configuration.
+func (exec *mrExecutor) PipelineReload(p *Pipeline, user string) error {
 	rd, err := newFileReader(p.URI)
 	if err != nil {
 		return err
@@ -91,21 +122,15 @@ func (exec *mrExecutor) PipelineReload(p *Pipeline) error {
 		return err
 	}
 
-	if p.Config.Spec.Schedule != nil {
-		exec.cron.Delete(p.Name)
-	}
-
-	p.Config = conf
-	for _, instance := range p.Instances {
-		// instance.JobsStatus = nil
-		instance.Stage = 0
-	}
-
-	if sched := p.Config.Spec.Schedule; sched != nil {
-		t := &pipelineTrigger{exec, p}
-		exec.cron.Add(p.Name, sched, t.trigger)
+	exec.Lock()
+	err = exec.checkTriggerCycle(p.Name, conf.Spec)
+	exec.Unlock()
+	if err != nil {
+		return err
 	}
 
+	// the config is installed by the executor goroutine
+	exec.events <- &evConfigInstall{p, conf, p.URI, user}
 	return nil
 }
 
@@ -138,6 +163,14 @@ func (exec *mrExecutor) PipelineCount() int {
 	return len(exec.pipelines)
 }
 
+// createInstance adds an instance to the pipeline under the lock, since the
+// API reads the instance list concurrently.
+func (exec *mrExecutor) createInstance(p *Pipeline, opts *instanceOptions) (*Instance, error) {
+	exec.Lock()
+	defer exec.Unlock()
+	return p.createInstance(opts)
+}
+
 type pipelineTrigger struct {
 	exec *mrExecutor
 	p    *Pipeline
@@ -145,16 +178,34 @@ type pipelineTrigger struct {
 
 func (t *pipelineTrigger) trigger() {
 	// glog.V(2).Info("trigger for ", t.p.Name)
-	instance := t.p.createInstance()
+	params, err := expandScheduleParams(t.p.Config.Spec.Schedule, time.Now().UTC())
+	if err != nil {
+		log.Printf("%s: %v", t.p.Name, err)
+		return
+	}
+	t.start(&instanceOptions{params: params})
+}
+
+// start creates a new instance of the pipeline and runs it from the first stage.
+func (t *pipelineTrigger) start(opts *instanceOptions) {
+	instance, err := t.exec.createInstance(t.p, opts)
+	if err != nil {
+		log.Printf("%s: %v", t.p.Name, err)
+		return
+	}
 	t.exec.events <- &evPipelineRun{t.p, instance.ID, 0}
 }
 
-func (exec *mrExecutor) SetState(p *Pipeline, action StateAction, instanceID int, stage int) error {
-	switch action {
+func (exec *mrExecutor) SetState(p *Pipeline, request *StateRequest) error {
+	instanceID, stage := request.ID, request.Stage
+	switch request.Action {
 	case ActionStart:
 		if instanceID == 0 {
 			// start new instance
-			instance := p.createInstance()
+			instance, err := exec.createInstance(p, &instanceOptions{params: request.Params, priority: request.Priority})
+			if err != nil {
+				return err
+			}
 			exec.events <- &evPipelineRun{p, instance.ID, 0}
 		} else {
 			// restart an existing instance
@@ -162,11 +213,19 @@ func (exec *mrExecutor) SetState(p *Pipeline, action StateAction, instanceID int
 			if instance == nil {
 				return fmt.Errorf("Instance id %d not found", instanceID)
 			}
+			if instance.isCloning() {
+				return fmt.Errorf("Instance %d is being cloned", instanceID)
+			}
 			exec.events <- &evPipelineRun{p, instanceID, stage}
 		}
 	case ActionStop:
 		if instanceID == 0 {
 			// stop all instances
+			for _, instance := range p.Instances {
+				if instance.isActive() {
+					exec.events <- &evTaskAbort{p, instance.ID, instance.Stage, "User request", time.Now()}
+				}
+			}
 		} else {
 			// stop a specific instance
 			instance := p.getInstance(instanceID)
@@ -175,29 +234,62 @@ func (exec *mrExecutor) SetState(p *Pipeline, action StateAction, instanceID int
 			}
 			exec.events <- &evTaskAbort{p, instanceID, instance.Stage, "User request", time.Now()}
 		}
+	case ActionApprove, ActionReject:
+		instance := p.getInstance(instanceID)
+		if instance == nil {
+			return fmt.Errorf("Invalid instance ID %d", instanceID)
+		}
+		exec.events <- &evTaskApproval{
+			pipeline:   p,
+			instanceID: instanceID,
+			taskIndex:  instance.Stage,
+			approved:   request.Action == ActionApprove,
+			approver:   request.Approver,
+			comment:    request.Comment,
+		}
+	case ActionUpgrade:
+		instance := p.getInstance(instanceID)
+		if instance == nil {
+			return fmt.Errorf("Invalid instance ID %d", instanceID)
+		}
+		exec.events <- &evInstanceUpgrade{p, instanceID}
+	case ActionPause, ActionResume:
+		instance := p.getInstance(instanceID)
+		if instance == nil {
+			return fmt.Errorf("Invalid instance ID %d", instanceID)
+		}
+		if !p.instanceSpec(instance).Tasks[instance.Stage].hasJobs() {
+			return fmt.Errorf("Task %s cannot be paused", p.instanceSpec(instance).Tasks[instance.Stage].Name)
+		}
+		exec.events <- &evInstancePause{p, instanceID, request.Action == ActionResume}
 	}
 	return nil
 }
 
-func (exec *mrExecutor) Clone(p *Pipeline, prevID int, includePat, excludePat string) error {
-	var reIncl, reExcl *regexp.Regexp
-	if includePat != "" {
-		var err error
-		if reIncl, err = regexp.Compile(includePat); err != nil {
-			return err
-		}
-	}
-	if excludePat != "" {
-		var err error
-		if reExcl, err = regexp.Compile(excludePat); err != nil {
-			return err
-		}
+func (exec *mrExecutor) BackfillCreate(p *Pipeline, request *BackfillRequest) (*Backfill, error) {
+	exec.Lock()
+	b, err := p.createBackfill(request)
+	exec.Unlock()
+	if err != nil {
+		return nil, err
 	}
+	exec.events <- &evBackfillSchedule{p}
+	return b, nil
+}
 
-	instance := p.createInstance()
-	prevDir := p.Config.Spec.Storage + "/" + strconv.Itoa(prevID)
-	workDir := p.Config.Spec.Storage + "/" + strconv.Itoa(instance.ID)
-	return copyWorkDir(prevDir, workDir, reIncl, reExcl)
+// BackfillUpdate changes the state of a backfill. The instances are started or
+// cancelled by the executor goroutine.
+func (exec *mrExecutor) BackfillUpdate(p *Pipeline, request *BackfillControlRequest) error {
+	exec.Lock()
+	ev, err := p.updateBackfill(request)
+	exec.Unlock()
+	if err != nil {
+		return err
+	}
+	if ev != nil {
+		exec.events <- ev
+	}
+	return nil
 }
 
 func (exec *mrExecutor) Start() {
@@ -215,8 +307,13 @@ func (exec *mrExecutor) Configure(uri string) error {
 	if err := decoder.Decode(&pipelines); err != nil {
 		return err
 	}
+	exec.Lock()
 	exec.pipelines = pipelines
+	exec.restoreQueue()
+	exec.Unlock()
 	for _, p := range pipelines {
+		p.setConfig(p.Config)
+		p.restoreClones()
 		if p.Config.Spec.Schedule == nil {
 			continue
 		}
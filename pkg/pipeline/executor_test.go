@@ -75,7 +75,7 @@ func TestPipelineExec(t *testing.T) {
 	}
 	exec.pipelines[pipeline.Name] = pipeline
 
-	exec.SetState(pipeline, ActionStart, 0, 0)
+	exec.SetState(pipeline, &StateRequest{Action: ActionStart})
 
 	timeout := time.NewTicker(time.Second)
 	exec.runOnce(timeout)
@@ -178,7 +178,7 @@ func TestTaskAbort(t *testing.T) {
 	}
 	exec.pipelines[pipeline.Name] = pipeline
 
-	exec.SetState(pipeline, ActionStart, 0, 0)
+	exec.SetState(pipeline, &StateRequest{Action: ActionStart})
 
 	timeout := time.NewTicker(time.Second)
 	exec.runOnce(timeout)
@@ -258,7 +258,7 @@ func TestPipelineRestart(t *testing.T) {
 	}
 	exec.pipelines[pipeline.Name] = pipeline
 
-	exec.SetState(pipeline, ActionStart, 0, 0)
+	exec.SetState(pipeline, &StateRequest{Action: ActionStart})
 
 	timeout := time.NewTicker(time.Second)
 	exec.runOnce(timeout)
@@ -276,7 +276,7 @@ func TestPipelineRestart(t *testing.T) {
 		t.Error(len(jobList.Items))
 	}
 
-	exec.SetState(pipeline, ActionStop, 1, 0)
+	exec.SetState(pipeline, &StateRequest{Action: ActionStop, ID: 1})
 	exec.runOnce(timeout)
 
 	jobList, err = exec.k8sClient.BatchV1().Jobs(config.Spec.Namespace).List(api_v1.ListOptions{})
@@ -297,7 +297,7 @@ func TestPipelineRestart(t *testing.T) {
 		t.Error(pipeline.State)
 	}
 
-	exec.SetState(pipeline, ActionStart, 1, 0)
+	exec.SetState(pipeline, &StateRequest{Action: ActionStart, ID: 1})
 	exec.runOnce(timeout)
 	if pipeline.State != StateRunning {
 		t.Error(pipeline.State)
@@ -313,3 +313,347 @@ func TestPipelineRestart(t *testing.T) {
 	}
 
 }
+
+func TestPipelineTrigger(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		dataDir:   "testdata",
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+
+	upstream := &Pipeline{
+		Name:  "upstream",
+		State: StateStopped,
+		Config: &Config{
+			Spec: &Spec{
+				Name:      "upstream",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/upstream",
+				Tasks: []TaskSpec{
+					{
+						Name: "step1",
+						JobTemplate: JobTemplate{
+							Image:       "step1",
+							Instances:   1,
+							Parallelism: 1,
+						},
+					},
+				},
+			},
+		},
+	}
+	downstream := &Pipeline{
+		Name:  "downstream",
+		State: StateStopped,
+		Config: &Config{
+			Spec: &Spec{
+				Name:      "downstream",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/downstream",
+				Triggers:  []TriggerSpec{{Pipeline: "upstream"}},
+				Tasks: []TaskSpec{
+					{
+						Name: "step1",
+						JobTemplate: JobTemplate{
+							Image:       "step1",
+							Instances:   1,
+							Parallelism: 1,
+							Args:        []string{"-input={{.Trigger.WorkDir}}"},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, p := range []*Pipeline{upstream, downstream} {
+		defaultPipelineSpecValues(p.Config.Spec, "../../templates")
+		exec.pipelines[p.Name] = p
+	}
+
+	exec.SetState(upstream, &StateRequest{Action: ActionStart})
+
+	timeout := time.NewTicker(time.Second)
+	exec.runOnce(timeout)
+	exec.runOnce(timeout)
+
+	jobList, err := exec.k8sClient.BatchV1().Jobs("roque").List(api_v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobList.Items) != 1 {
+		t.Fatal(len(jobList.Items))
+	}
+	genJobCompletionEvent(exec, upstream, &jobList.Items[0])
+
+	exec.runOnce(timeout)
+	exec.runOnce(timeout)
+
+	if upstream.Instances[0].State != StateComplete {
+		t.Error(upstream.Instances[0].State)
+	}
+	if len(downstream.Instances) != 1 {
+		t.Fatal(len(downstream.Instances))
+	}
+	trigger := downstream.Instances[0].Trigger
+	if trigger == nil || trigger.Pipeline != "upstream" || trigger.ID != 1 {
+		t.Fatalf("unexpected trigger %+v", trigger)
+	}
+	if trigger.WorkDir != "gs://laserlike_roque/upstream/1" {
+		t.Error(trigger.WorkDir)
+	}
+}
+
+func TestSubPipeline(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		dataDir:   "testdata",
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+
+	child := &Pipeline{
+		Name:  "child",
+		State: StateStopped,
+		Config: &Config{
+			Spec: &Spec{
+				Name:      "child",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/child",
+				Tasks: []TaskSpec{
+					{
+						Name: "normalize",
+						JobTemplate: JobTemplate{
+							Image:       "normalize",
+							Instances:   1,
+							Parallelism: 1,
+						},
+					},
+				},
+			},
+		},
+	}
+	parent := &Pipeline{
+		Name:  "parent",
+		State: StateStopped,
+		Config: &Config{
+			Spec: &Spec{
+				Name:      "parent",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/parent",
+				Tasks: []TaskSpec{
+					{
+						Name:     "prefix",
+						Pipeline: &SubPipelineSpec{Name: "child"},
+					},
+				},
+			},
+		},
+	}
+	for _, p := range []*Pipeline{child, parent} {
+		defaultPipelineSpecValues(p.Config.Spec, "../../templates")
+		exec.pipelines[p.Name] = p
+	}
+
+	exec.SetState(parent, &StateRequest{Action: ActionStart})
+
+	timeout := time.NewTicker(time.Second)
+	for i := 0; i < 4; i++ {
+		exec.runOnce(timeout)
+	}
+
+	if len(child.Instances) != 1 {
+		t.Fatal(len(child.Instances))
+	}
+	ref := parent.Instances[0].Children["prefix"]
+	if ref == nil || ref.ID != 1 || ref.WorkDir != "gs://laserlike_roque/child/1" {
+		t.Fatalf("unexpected child %+v", ref)
+	}
+
+	jobList, err := exec.k8sClient.BatchV1().Jobs("roque").List(api_v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobList.Items) != 1 {
+		t.Fatal(len(jobList.Items))
+	}
+	genJobCompletionEvent(exec, child, &jobList.Items[0])
+	for i := 0; i < 4; i++ {
+		exec.runOnce(timeout)
+	}
+
+	if state := child.Instances[0].State; state != StateComplete {
+		t.Error(state)
+	}
+	if state := parent.Instances[0].State; state != StateComplete {
+		t.Error(state)
+	}
+}
+
+func TestSubPipelineRecursion(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	makePipeline := func(name, sub string) *Pipeline {
+		return &Pipeline{
+			Name: name,
+			Config: &Config{Spec: &Spec{
+				Name:  name,
+				Tasks: []TaskSpec{{Name: "run-" + sub, Pipeline: &SubPipelineSpec{Name: sub}}},
+			}},
+		}
+	}
+	a := makePipeline("a", "b")
+	b := makePipeline("b", "a")
+	c := makePipeline("c", "c")
+	exec.pipelines["a"] = a
+	exec.pipelines["b"] = b
+	exec.pipelines["c"] = c
+
+	a.Instances = []*Instance{{ID: 1, State: StateRunning, TaskList: []*Task{{}}}}
+	b.Instances = []*Instance{{ID: 1, State: StateRunning, TaskList: []*Task{{}}, Parent: &InstanceRef{Pipeline: "a", ID: 1}}}
+	c.Instances = []*Instance{{ID: 1, State: StateRunning, TaskList: []*Task{{}}}}
+
+	if err := exec.startSubPipeline(b, b.Instances[0], 0); err == nil {
+		t.Error("expected a -> b -> a to be refused")
+	}
+	if err := exec.startSubPipeline(c, c.Instances[0], 0); err == nil {
+		t.Error("expected c -> c to be refused")
+	}
+	if len(a.Instances) != 1 || len(c.Instances) != 1 {
+		t.Error("child instance created")
+	}
+	if err := exec.startSubPipeline(a, a.Instances[0], 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApproval(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		dataDir:   "testdata",
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+
+	p := &Pipeline{
+		Name:  "release",
+		State: StateStopped,
+		Config: &Config{
+			Spec: &Spec{
+				Name:      "release",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/release",
+				Tasks: []TaskSpec{
+					{
+						Name:     "signoff",
+						Approval: &ApprovalSpec{},
+					},
+				},
+			},
+		},
+	}
+	defaultPipelineSpecValues(p.Config.Spec, "../../templates")
+	exec.pipelines[p.Name] = p
+
+	exec.SetState(p, &StateRequest{Action: ActionStart})
+
+	timeout := time.NewTicker(time.Second)
+	for i := 0; i < 2; i++ {
+		exec.runOnce(timeout)
+	}
+
+	instance := p.Instances[0]
+	if instance.State != StateWaitingApproval {
+		t.Fatal(instance.State)
+	}
+
+	err := exec.SetState(p, &StateRequest{Action: ActionApprove, ID: instance.ID, Approver: "jdoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		exec.runOnce(timeout)
+	}
+
+	if instance.State != StateComplete {
+		t.Error(instance.State)
+	}
+	if len(instance.Approvals) != 1 || instance.Approvals[0].Approver != "jdoe" || !instance.Approvals[0].Approved {
+		t.Error(instance.Approvals)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		dataDir:   "testdata",
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+
+	p := &Pipeline{
+		Name:  "example",
+		State: StateStopped,
+		Config: &Config{
+			Spec: &Spec{
+				Name:      "example",
+				Namespace: "roque",
+				Storage:   "gs://laserlike_roque/example",
+				Tasks: []TaskSpec{
+					{
+						Name: "normalize",
+						JobTemplate: JobTemplate{
+							Image:       "normalize",
+							Instances:   4,
+							Parallelism: 2,
+						},
+					},
+				},
+			},
+		},
+	}
+	defaultPipelineSpecValues(p.Config.Spec, "../../templates")
+	exec.pipelines[p.Name] = p
+
+	exec.SetState(p, &StateRequest{Action: ActionStart})
+	timeout := time.NewTicker(time.Second)
+	for i := 0; i < 2; i++ {
+		exec.runOnce(timeout)
+	}
+
+	instance := p.Instances[0]
+	jobParallelism := func() int32 {
+		jobList, err := exec.k8sClient.BatchV1().Jobs("roque").List(api_v1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobList.Items) != 1 {
+			t.Fatal(len(jobList.Items))
+		}
+		return *jobList.Items[0].Spec.Parallelism
+	}
+
+	if err := exec.SetState(p, &StateRequest{Action: ActionPause, ID: instance.ID}); err != nil {
+		t.Fatal(err)
+	}
+	exec.runOnce(timeout)
+	if instance.State != StatePaused {
+		t.Fatal(instance.State)
+	}
+	if n := jobParallelism(); n != 0 {
+		t.Error(n)
+	}
+
+	if err := exec.SetState(p, &StateRequest{Action: ActionResume, ID: instance.ID}); err != nil {
+		t.Fatal(err)
+	}
+	exec.runOnce(timeout)
+	if instance.State != StateRunning {
+		t.Fatal(instance.State)
+	}
+	if n := jobParallelism(); n != 2 {
+		t.Error(n)
+	}
+}
@@ -2,90 +2,14 @@ package pipeline
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
-
-	"google.golang.org/api/iterator"
-
-	"cloud.google.com/go/storage"
-)
-
-const (
-	fileScheme          = "file://"
-	googleStorageScheme = "gs://"
+	"sync"
 )
 
-func newGCloudReader(uri string) (io.ReadCloser, error) {
-	path := uri[len(googleStorageScheme):]
-	elements := strings.SplitN(path, "/", 2)
-	if len(elements) < 2 {
-		return nil, fmt.Errorf("invalid google storage uri: %s", uri)
-	}
-
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer client.Close()
-
-	bkt := client.Bucket(elements[0])
-	obj := bkt.Object(elements[1])
-
-	ctx, _ = context.WithTimeout(ctx, time.Minute)
-	return obj.NewReader(ctx)
-}
-
-func newGCloudWriter(uri string) (io.WriteCloser, error) {
-	path := uri[len(googleStorageScheme):]
-	elements := strings.SplitN(path, "/", 2)
-	if len(elements) < 2 {
-		return nil, fmt.Errorf("invalid google storage uri: %s", uri)
-	}
-
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer client.Close()
-
-	bkt := client.Bucket(elements[0])
-	obj := bkt.Object(elements[1])
-
-	ctx, _ = context.WithTimeout(ctx, time.Minute)
-	return obj.NewWriter(ctx), nil
-}
-
-// newFileReader allocates a file reader. It is the responsibility of the caller to
-// call Close().
-func newFileReader(uri string) (io.ReadCloser, error) {
-	switch {
-	case strings.HasPrefix(uri, googleStorageScheme):
-		return newGCloudReader(uri)
-	case strings.HasPrefix(uri, fileScheme):
-		return os.Open(uri[len(fileScheme):])
-	}
-	return nil, fmt.Errorf("unsupported uri scheme: %s", uri)
-}
-
-func newFileWriter(uri string) (io.WriteCloser, error) {
-	switch {
-	case strings.HasPrefix(uri, googleStorageScheme):
-		return newGCloudWriter(uri)
-	case strings.HasPrefix(uri, fileScheme):
-		return os.Create(uri[len(fileScheme):])
-	}
-	return nil, fmt.Errorf("unsupported uri scheme: %s", uri)
-}
-
 func newLocalCopy(uri string, prefix string) (string, error) {
 	rd, err := newFileReader(uri)
 	if err != nil {
@@ -107,72 +31,92 @@ func newLocalCopy(uri string, prefix string) (string, error) {
 	return tmpFile.Name(), err
 }
 
-func gsCopyWorkDir(src, dst string, reIncl, reExcl *regexp.Regexp) error {
-	if !strings.HasPrefix(src, googleStorageScheme) {
-		return fmt.Errorf("source directory (%s) not a gs uri", src)
-	}
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer client.Close()
+// copyWorkDirWorkers is the number of objects copied in parallel.
+const copyWorkDirWorkers = 8
 
-	srcElements := strings.SplitN(src[len(googleStorageScheme):], "/", 2)
-	if len(srcElements) < 2 {
-		return fmt.Errorf("invalid google storage uri: %s", src)
-	}
-
-	dstElements := strings.SplitN(dst[len(googleStorageScheme):], "/", 2)
-	if len(dstElements) < 2 {
-		return fmt.Errorf("invalid google storage uri: %s", dst)
+// workDirObjects lists the names, relative to src, of the objects to copy
+// from a work directory. The include and exclude expressions are matched
+// against the object names.
+func workDirObjects(src string, reIncl, reExcl *regexp.Regexp) ([]string, error) {
+	backend, err := storageFor(src)
+	if err != nil {
+		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
 
-	srcBucket := client.Bucket(srcElements[0])
-	var dstBucket *storage.BucketHandle
-	if dstElements[0] != srcElements[0] {
-		dstBucket = client.Bucket(dstElements[0])
-	} else {
-		dstBucket = srcBucket
+	prefix := strings.TrimSuffix(src, "/") + "/"
+	objects, err := backend.List(ctx, prefix, 0)
+	if err != nil {
+		return nil, err
 	}
 
-	q := &storage.Query{Prefix: srcElements[1]}
-	iter := srcBucket.Objects(ctx, q)
-	for {
-		obj, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil
-		}
-		name := obj.Name[len(srcElements[1]):]
-		if name[0] == '/' {
-			name = name[1:]
-		}
-
+	var names []string
+	for _, obj := range objects {
+		name := obj.URI[len(prefix):]
 		if reIncl != nil && !reIncl.MatchString(name) {
 			continue
 		}
 		if reExcl != nil && reExcl.MatchString(name) {
 			continue
 		}
-		s := srcBucket.Object(obj.Name)
-		d := dstBucket.Object(filepath.Join(dstElements[1], name))
-		copier := d.CopierFrom(s)
-		if _, err := copier.Run(ctx); err != nil {
-			log.Println(err)
-		}
+		names = append(names, name)
 	}
+	return names, nil
+}
 
-	return nil
+// copyObjects copies the named objects from src to dst in parallel and calls
+// done with the result of each copy.
+func copyObjects(src, dst string, names []string, done func(name string, err error)) {
+	copyOne := func(name string) error {
+		if strings.HasPrefix(dst, fileScheme) {
+			if err := checkLocalTarget(filePath(dst), name); err != nil {
+				return err
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+		defer cancel()
+		return copyObject(ctx, pathJoin(src, name), pathJoin(dst, name))
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < copyWorkDirWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				done(name, copyOne(name))
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
 }
 
+// copyWorkDir copies the objects under src to dst. The include and exclude
+// expressions are matched against the object names relative to src. It
+// returns the first copy error.
 func copyWorkDir(src, dst string, reIncl, reExcl *regexp.Regexp) error {
-	switch {
-	case strings.HasPrefix(dst, googleStorageScheme):
-		return gsCopyWorkDir(src, dst, reIncl, reExcl)
-	default:
-		return fmt.Errorf("unsupported uri scheme: %s", dst)
+	if _, err := storageFor(dst); err != nil {
+		return err
+	}
+	names, err := workDirObjects(src, reIncl, reExcl)
+	if err != nil {
+		return err
 	}
+
+	var mutex sync.Mutex
+	var first error
+	copyObjects(src, dst, names, func(name string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil && first == nil {
+			first = err
+		}
+	})
+	return first
 }
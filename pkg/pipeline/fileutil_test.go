@@ -3,7 +3,11 @@ package pipeline
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -36,3 +40,52 @@ func TestDirCopy(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFileDirCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "1")
+	os.MkdirAll(filepath.Join(src, "data"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "data/part-0"), []byte("0"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "data/part-1"), []byte("1"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh"), 0750)
+	outside := filepath.Join(dir, "outside")
+	ioutil.WriteFile(outside, []byte("secret"), 0600)
+	if err := os.Symlink(outside, filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "2")
+	if err := copyWorkDir(fileScheme+src, fileScheme+dst, nil, regexp.MustCompile("part-1$")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := listFiles(fileScheme + dst + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{fileScheme + dst + "/data/part-0", fileScheme + dst + "/run.sh"}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "run.sh")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("mode not preserved: %v %v", info, err)
+	}
+
+	// a destination directory that links out of the work dir is refused
+	escape := filepath.Join(dir, "3")
+	os.MkdirAll(escape, 0755)
+	if err := os.Symlink(filepath.Join(dir, "outside-dir"), filepath.Join(escape, "data")); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "outside-dir"), 0755)
+	if err := copyWorkDir(fileScheme+src, fileScheme+escape, regexp.MustCompile("^data/"), nil); err == nil {
+		t.Error("expected symlink escape to be refused")
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "outside-dir")); len(files) != 0 {
+		t.Errorf("files written outside of the work dir: %d", len(files))
+	}
+}
@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// hasGlobMeta returns true if the path contains glob special characters.
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// validateGlob checks the syntax of a path pattern.
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s", pattern)
+		}
+	}
+	return nil
+}
+
+// matchGlob matches a slash separated name against a pattern. Pattern
+// segments use the path.Match syntax, and a "**" segment matches any number
+// of segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// resolvePath returns the objects that a task input or output refers to. A
+// path is either a glob pattern, a prefix that ends in "/", an object or a
+// directory. The pod logs saved in workDir are not included in listings,
+// unless the path is within the log directory.
+func resolvePath(ctx context.Context, uri, workDir string) ([]ObjectInfo, error) {
+	backend, err := storageFor(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := strings.IndexAny(uri, "*?["); i >= 0 {
+		prefix := uri[:strings.LastIndex(uri[:i], "/")+1]
+		objects, err := backend.List(ctx, prefix, 0)
+		if err != nil {
+			return nil, err
+		}
+		pattern := uri[len(prefix):]
+		var matches []ObjectInfo
+		for _, obj := range objects {
+			if matchGlob(pattern, obj.URI[len(prefix):]) {
+				matches = append(matches, obj)
+			}
+		}
+		return excludePodLogs(matches, prefix, workDir), nil
+	}
+
+	if !strings.HasSuffix(uri, "/") {
+		info, err := backend.Stat(ctx, uri)
+		if err == nil {
+			return []ObjectInfo{*info}, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		// a path that is not an object is a directory
+		uri += "/"
+	}
+	objects, err := backend.List(ctx, uri, 0)
+	if err != nil {
+		return nil, err
+	}
+	return excludePodLogs(objects, uri, workDir), nil
+}
+
+// excludePodLogs removes the pod logs of a work directory from the objects
+// listed under prefix, so that they don't become task inputs or outputs.
+func excludePodLogs(objects []ObjectInfo, prefix, workDir string) []ObjectInfo {
+	if workDir == "" {
+		return objects
+	}
+	logDir := podLogDir(workDir)
+	if strings.HasPrefix(prefix, logDir) {
+		return objects
+	}
+	var result []ObjectInfo
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.URI, logDir) {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+// objectChecksum returns the checksum provided by the storage service or
+// computes the SHA-256 hash of the object.
+func objectChecksum(ctx context.Context, obj *ObjectInfo) (string, error) {
+	if obj.Checksum != "" {
+		return obj.Checksum, nil
+	}
+	backend, err := storageFor(obj.URI)
+	if err != nil {
+		return "", err
+	}
+	rd, err := backend.Open(ctx, obj.URI)
+	if err != nil {
+		return "", err
+	}
+	defer rd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rd); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"data/part-*", "data/part-0", true},
+		{"data/part-*", "data/sub/part-0", false},
+		{"data/**/part-*", "data/part-0", true},
+		{"data/**/part-*", "data/a/b/part-0", true},
+		{"**/*.csv", "x/y.csv", true},
+		{"**/*.csv", "x/y.tsv", false},
+		{"model-?", "model-1", true},
+		{"model-[0-9]", "model-a", false},
+		{"data/**", "data/a/b", true},
+	}
+	for _, tc := range testCases {
+		if matchGlob(tc.pattern, tc.name) != tc.match {
+			t.Errorf("%s %s: expected %t", tc.pattern, tc.name, tc.match)
+		}
+	}
+	if err := validateGlob("data/[a-"); err == nil {
+		t.Error("expected an invalid pattern error")
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	writeObject(t, "mem://glob/1/data/part-0", "0")
+	writeObject(t, "mem://glob/1/data/part-1", "1")
+	writeObject(t, "mem://glob/1/data/sub/part-2", "2")
+	writeObject(t, "mem://glob/1/model", "m")
+	writeObject(t, podLogURI("mem://glob/1", "step1", "step1-0"), "log")
+
+	testCases := []struct {
+		uri   string
+		count int
+	}{
+		{"mem://glob/1/data/part-*", 2},
+		{"mem://glob/1/data/**/part-*", 3},
+		{"mem://glob/1/data/", 3},
+		{"mem://glob/1/data", 3},
+		{"mem://glob/1/model", 1},
+		{"mem://glob/1/missing", 0},
+		{"mem://glob/1/*.csv", 0},
+		// the saved pod logs are only listed explicitly
+		{"mem://glob/1/**", 4},
+		{"mem://glob/1/", 4},
+		{"mem://glob/1/logs/", 1},
+		{"mem://glob/1/logs/step1/*.log", 1},
+	}
+	for _, tc := range testCases {
+		objects, err := resolvePath(context.Background(), tc.uri, "mem://glob/1")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(objects) != tc.count {
+			t.Errorf("%s: expected %d objects, got %+v", tc.uri, tc.count, objects)
+		}
+	}
+}
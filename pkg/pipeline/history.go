@@ -0,0 +1,244 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigVersion records a configuration load.
+type ConfigVersion struct {
+	Hash     string
+	URI      string
+	LoadTime time.Time
+	User     string `json:",omitempty"`
+}
+
+// SpecChange is a difference between two versions of a pipeline spec.
+type SpecChange struct {
+	Path string
+	Old  interface{} `json:",omitempty"`
+	New  interface{} `json:",omitempty"`
+}
+
+// maxConfigVersions is the number of history entries retained per pipeline.
+const maxConfigVersions = 20
+
+// addHistory records that a config version was loaded. Loads that don't
+// change the config are not recorded.
+func (p *Pipeline) addHistory(conf *Config, uri, user string) {
+	key := configKey(conf.Hash)
+	if n := len(p.History); n > 0 && p.History[n-1].Hash == key {
+		return
+	}
+	p.History = append(p.History, &ConfigVersion{
+		Hash:     key,
+		URI:      uri,
+		LoadTime: time.Now(),
+		User:     user,
+	})
+	if len(p.History) > maxConfigVersions {
+		p.History = append([]*ConfigVersion(nil), p.History[len(p.History)-maxConfigVersions:]...)
+	}
+}
+
+// pruneConfigs deletes the config versions that are neither in the history,
+// current, nor used by an instance.
+func (p *Pipeline) pruneConfigs() {
+	keep := map[string]bool{configKey(p.Config.Hash): true}
+	for _, v := range p.History {
+		keep[v.Hash] = true
+	}
+	for _, instance := range p.Instances {
+		keep[configKey(instance.Hash)] = true
+	}
+	for k := range p.Configs {
+		if !keep[k] {
+			delete(p.Configs, k)
+		}
+	}
+}
+
+// lookupConfig returns the config version identified by a hash or a unique
+// hash prefix.
+func (p *Pipeline) lookupConfig(hash string) (*Config, error) {
+	if hash == "" {
+		return nil, fmt.Errorf("config hash must be specified")
+	}
+	var found *Config
+	for k, conf := range p.Configs {
+		if !strings.HasPrefix(k, hash) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("ambiguous config hash %s", hash)
+		}
+		found = conf
+	}
+	if found == nil {
+		return nil, fmt.Errorf("unknown config version %s", hash)
+	}
+	return found, nil
+}
+
+// installConfig makes conf the current configuration of the pipeline and
+// updates the cron schedule. Configurations that create a cycle of triggers
+// are rejected. Must be called with the lock held.
+func (exec *mrExecutor) installConfig(p *Pipeline, conf *Config, uri, user string) error {
+	if err := exec.checkTriggerCycle(p.Name, conf.Spec); err != nil {
+		return err
+	}
+	if p.Config != nil && p.Config.Spec.Schedule != nil {
+		exec.cron.Delete(p.Name)
+	}
+
+	p.setConfig(conf)
+	p.addHistory(conf, uri, user)
+	p.pruneConfigs()
+
+	if sched := p.Config.Spec.Schedule; sched != nil {
+		t := &pipelineTrigger{exec, p}
+		exec.cron.Add(p.Name, sched, t.trigger)
+	}
+	return nil
+}
+
+type evConfigInstall struct {
+	pipeline *Pipeline
+	conf     *Config
+	uri      string
+	user     string
+}
+
+func (ev *evConfigInstall) eventType() smEventType { return eventConfigInstall }
+func (ev *evConfigInstall) String() string {
+	return fmt.Sprintf("CONFIG %s %s", ev.pipeline.Name, configKey(ev.conf.Hash))
+}
+
+func (exec *mrExecutor) handleConfigInstall(event *evConfigInstall) {
+	exec.Lock()
+	defer exec.Unlock()
+	if err := exec.installConfig(event.pipeline, event.conf, event.uri, event.user); err != nil {
+		log.Printf("%s: %v", event.pipeline.Name, err)
+	}
+}
+
+// LookupConfig returns the config version identified by a hash prefix, or the
+// current config when hash is empty.
+func (exec *mrExecutor) LookupConfig(p *Pipeline, hash string) (*Config, error) {
+	exec.Lock()
+	defer exec.Unlock()
+	if hash == "" {
+		return p.Config, nil
+	}
+	return p.lookupConfig(hash)
+}
+
+// PipelineRollback restores a previously loaded configuration version. The
+// config is installed by the executor goroutine.
+func (exec *mrExecutor) PipelineRollback(p *Pipeline, hash, user string) error {
+	exec.Lock()
+	conf, err := p.lookupConfig(hash)
+	if err != nil {
+		exec.Unlock()
+		return err
+	}
+	if err := exec.checkTriggerCycle(p.Name, conf.Spec); err != nil {
+		exec.Unlock()
+		return err
+	}
+	uri := p.URI
+	key := configKey(conf.Hash)
+	for _, v := range p.History {
+		if v.Hash == key {
+			uri = v.URI
+		}
+	}
+	exec.Unlock()
+
+	exec.events <- &evConfigInstall{p, conf, uri, user}
+	return nil
+}
+
+// diffSpecs compares two pipeline specs and returns the changed fields.
+func diffSpecs(from, to *Spec) ([]SpecChange, error) {
+	var a, b interface{}
+	if err := specToMap(from, &a); err != nil {
+		return nil, err
+	}
+	if err := specToMap(to, &b); err != nil {
+		return nil, err
+	}
+	var changes []SpecChange
+	diffValues("", a, b, &changes)
+	return changes, nil
+}
+
+func specToMap(spec *Spec, v *interface{}) error {
+	js, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+func diffValues(path string, a, b interface{}, changes *[]SpecChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffValues(joinPath(path, k), av[k], bv[k], changes)
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(av)
+		if len(bv) > n {
+			n = len(bv)
+		}
+		for i := 0; i < n; i++ {
+			var x, y interface{}
+			if i < len(av) {
+				x = av[i]
+			}
+			if i < len(bv) {
+				y = bv[i]
+			}
+			diffValues(path+"["+strconv.Itoa(i)+"]", x, y, changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, SpecChange{Path: path, Old: a, New: b})
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
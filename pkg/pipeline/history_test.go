@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"testing"
+)
+
+func TestDiffSpecs(t *testing.T) {
+	from := &Spec{
+		Name:  "nightly",
+		Tasks: []TaskSpec{{Name: "build", JobTemplate: JobTemplate{Image: "build:v1", Args: []string{"-v=1"}}}},
+	}
+	to := &Spec{
+		Name:  "nightly",
+		Tasks: []TaskSpec{{Name: "build", JobTemplate: JobTemplate{Image: "build:v2", Args: []string{"-v=1", "-fast"}}}},
+	}
+	changes, err := diffSpecs(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		"Tasks[0].Image":   true,
+		"Tasks[0].Args[1]": true,
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	for _, c := range changes {
+		if !expected[c.Path] {
+			t.Errorf("unexpected change %s", c.Path)
+		}
+	}
+}
+
+func TestPipelineRollback(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	v1 := &Config{Hash: []byte{0xab, 0x01}, Spec: &Spec{Name: "nightly"}}
+	v2 := &Config{Hash: []byte{0xcd, 0x02}, Spec: &Spec{Name: "nightly"}}
+
+	p := &Pipeline{Name: "nightly", URI: "gs://bucket/nightly.yaml"}
+	exec.installConfig(p, v1, p.URI, "alice")
+	exec.installConfig(p, v2, p.URI, "bob")
+
+	exec.installConfig(p, v2, p.URI, "bob")
+	if len(p.History) != 2 {
+		t.Errorf("unchanged config recorded: %d", len(p.History))
+	}
+
+	if err := exec.PipelineRollback(p, "ab", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Config != v2 {
+		t.Error("config installed outside of the executor")
+	}
+	exec.handleConfigInstall(nextEvent(t, exec).(*evConfigInstall))
+	if p.Config != v1 {
+		t.Error("config not restored")
+	}
+	if len(p.History) != 3 || p.History[2].User != "carol" || p.History[2].Hash != "ab01" {
+		t.Errorf("unexpected history %+v", p.History[len(p.History)-1])
+	}
+	if err := exec.PipelineRollback(p, "ff", ""); err == nil {
+		t.Error("expected error for unknown version")
+	}
+
+	// old versions are dropped unless an instance uses them
+	p.Instances = []*Instance{{ID: 1, Hash: v2.Hash}}
+	for i := 0; i < maxConfigVersions; i++ {
+		exec.installConfig(p, &Config{Hash: []byte{byte(i)}, Spec: &Spec{Name: "nightly"}}, p.URI, "")
+	}
+	if len(p.History) != maxConfigVersions {
+		t.Errorf("history not capped: %d", len(p.History))
+	}
+	if _, ok := p.Configs["ab01"]; ok {
+		t.Error("unused version retained")
+	}
+	if _, ok := p.Configs["cd02"]; !ok {
+		t.Error("version used by an instance deleted")
+	}
+}
+
+func TestTriggerCycle(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		exec.pipelines[name] = &Pipeline{Name: name}
+	}
+	install := func(name string, upstream ...string) error {
+		spec := &Spec{Name: name}
+		for _, u := range upstream {
+			spec.Triggers = append(spec.Triggers, TriggerSpec{Pipeline: u, Task: "build"})
+		}
+		return exec.installConfig(exec.pipelines[name], &Config{Hash: []byte(name), Spec: spec}, "", "")
+	}
+
+	if err := install("b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := install("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := install("a", "c"); err == nil {
+		t.Error("expected an error for a -> b -> c -> a")
+	}
+	if exec.pipelines["a"].Config != nil {
+		t.Error("config installed despite the cycle")
+	}
+	if err := install("a", "d"); err != nil {
+		t.Error(err)
+	}
+	if err := validatePipelineConfig(&Spec{Name: "a", Triggers: []TriggerSpec{{Pipeline: "a", Task: "build"}}}); err == nil {
+		t.Error("expected an error for a self trigger")
+	}
+}
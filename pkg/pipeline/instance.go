@@ -12,18 +12,72 @@ type Instance struct {
 
 	// StartStage is stage that the pipeline was (re)started at.
 	StartStage int
-	Hash       []byte // TODO: md5 hash of config
+	// Hash of the configuration the instance executes with.
+	Hash []byte
 
 	workDir string
 
 	Stage int
 	State ExecState
+	// EndTime is the time the instance completed or was stopped.
+	EndTime time.Time
+	// Reason explains why the instance is in its current state.
+	Reason string `json:",omitempty"`
 
 	TaskList []*Task
 
+	// Params are the run parameters of the instance.
+	Params map[string]string `json:",omitempty"`
+
+	// Backfill is the ID of the backfill that created the instance.
+	Backfill int `json:",omitempty"`
+
+	// Trigger is set when the instance was started by an upstream pipeline.
+	Trigger *InstanceTrigger `json:",omitempty"`
+
+	// Parent is set for instances executed as a task of another pipeline.
+	Parent *InstanceRef `json:",omitempty"`
+	// Children are the sub-pipeline instances created by this instance,
+	// indexed by task name.
+	Children map[string]*InstanceRef `json:",omitempty"`
+
+	// Priority determines the order in which queued instances start.
+	Priority int `json:",omitempty"`
+	// Preempted is set when the instance was paused to free an execution
+	// slot for a higher priority instance.
+	Preempted bool `json:",omitempty"`
+
+	// Approvals records the decisions on the approval tasks of the instance.
+	Approvals []*ApprovalRecord `json:",omitempty"`
+
+	// Clone is set for instances created by copying the work directory of
+	// another instance.
+	Clone *CloneStatus `json:",omitempty"`
+
+	// Outputs is the manifest of the objects produced by the tasks that
+	// declare outputs.
+	Outputs []*OutputRecord `json:",omitempty"`
+
 	watcher *Watcher
 }
 
+// InstanceTrigger identifies the upstream instance that started an instance.
+type InstanceTrigger struct {
+	Pipeline string
+	ID       int
+	Task     string `json:",omitempty"`
+	WorkDir  string `json:",omitempty"`
+}
+
+// InstanceRef identifies an instance of another pipeline.
+type InstanceRef struct {
+	Pipeline string
+	ID       int
+	Stage    int       `json:",omitempty"`
+	State    ExecState `json:",omitempty"`
+	WorkDir  string    `json:",omitempty"`
+}
+
 type taskStatus struct {
 	Running int
 	Success int
@@ -43,6 +97,15 @@ func makeInstance(id int) *Instance {
 	}
 }
 
+// isActive returns true while the instance is executing.
+func (instance *Instance) isActive() bool {
+	switch instance.State {
+	case StateRunning, StateWaitingApproval, StatePaused, StateQueued, StatePending:
+		return true
+	}
+	return false
+}
+
 func (s *jobStatus) IsRunning() bool {
 	return s.Active > 0
 }
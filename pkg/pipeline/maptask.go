@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	batch_v1 "k8s.io/client-go/pkg/apis/batch/v1"
+	"k8s.io/client-go/pkg/types"
+)
+
+const (
+	defaultMaxShards = 1000
+)
+
+func readManifest(uri string) ([]string, error) {
+	rd, err := newFileReader(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var inputs []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		inputs = append(inputs, line)
+	}
+	return inputs, scanner.Err()
+}
+
+// mapTaskInputs lists the shard inputs of a map task.
+func mapTaskInputs(spec *Spec, instance *Instance, taskSpec *TaskSpec) ([]string, error) {
+	vars := makeTemplateVars(spec, instance, taskSpec, &taskSpec.JobTemplate)
+	var inputs []string
+	if mspec := taskSpec.Map; mspec.Prefix != "" {
+		expanded, err := expandTemplateArgs(vars, []string{mspec.Prefix})
+		if err != nil {
+			return nil, err
+		}
+		if inputs, err = listFiles(expanded[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		expanded, err := expandTemplateArgs(vars, []string{mspec.Manifest})
+		if err != nil {
+			return nil, err
+		}
+		uri := expanded[0]
+		if !strings.Contains(uri, "://") {
+			uri = pathJoin(vars.Pipeline["WorkDir"], uri)
+		}
+		if inputs, err = readManifest(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	maxShards := taskSpec.Map.MaxShards
+	if maxShards == 0 {
+		maxShards = defaultMaxShards
+	}
+	if len(inputs) > maxShards {
+		return nil, fmt.Errorf("map task %s: %d inputs exceed the limit of %d shards", taskSpec.Name, len(inputs), maxShards)
+	}
+	return inputs, nil
+}
+
+// makeMapTask creates a Task with one job per shard. Each job is rendered with
+// the shard index and input in the template variables.
+func makeMapTask(spec *Spec, instance *Instance, taskSpec *TaskSpec, inputs []string) (*Task, error) {
+	var jobs []*batch_v1.Job
+	jspec := &taskSpec.JobTemplate
+	for i, input := range inputs {
+		vars := newTemplateVars(spec, instance, taskSpec, jspec)
+		vars.Task["Name"] = vars.Task["Name"] + "-" + strconv.Itoa(i)
+		vars.Shard["Index"] = strconv.Itoa(i)
+		vars.Shard["Count"] = strconv.Itoa(len(inputs))
+		vars.Shard["Input"] = input
+		vars.expandArgs(jspec.Args)
+
+		job, err := makeK8SJob(jspec.Template, vars)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return &Task{
+		jobs:   jobs,
+		JobIDs: make(map[string]types.UID),
+	}, nil
+}
+
+// renderTask generates the k8s objects for the task at the specified stage of
+// an instance. The inputs are the shard inputs of a map task. The task list is
+// updated under the lock, since the API reads it concurrently.
+func (exec *mrExecutor) renderTask(p *Pipeline, instance *Instance, stage int, inputs []string) error {
+	spec := p.instanceSpec(instance)
+	taskSpec := &spec.Tasks[stage]
+
+	var task *Task
+	var err error
+	if taskSpec.Map != nil {
+		if len(inputs) == 0 {
+			return fmt.Errorf("map task %s: no inputs found", taskSpec.Name)
+		}
+		task, err = makeMapTask(spec, instance, taskSpec, inputs)
+	} else {
+		task, err = makeTaskFromSpec(spec, instance, taskSpec)
+	}
+	if err != nil {
+		return err
+	}
+	exec.Lock()
+	instance.TaskList[stage] = task
+	exec.Unlock()
+	return nil
+}
+
+// startMapTask lists the shard inputs of a map task in the background, since
+// the storage service may be slow to respond. The task is rendered when the
+// inputs are known.
+func (exec *mrExecutor) startMapTask(p *Pipeline, instance *Instance, stage int) {
+	spec := p.instanceSpec(instance)
+	taskSpec := &spec.Tasks[stage]
+	go func() {
+		inputs, err := mapTaskInputs(spec, instance, taskSpec)
+		exec.events <- &evMapTaskInputs{p, instance.ID, stage, inputs, err}
+	}()
+}
+
+type evMapTaskInputs struct {
+	pipeline   *Pipeline
+	instanceID int
+	taskIndex  int
+	inputs     []string
+	err        error
+}
+
+func (ev *evMapTaskInputs) eventType() smEventType { return eventMapTaskInputs }
+func (ev *evMapTaskInputs) String() string {
+	return fmt.Sprintf("MAP INPUTS %s:%d task:%d %d", ev.pipeline.Name, ev.instanceID, ev.taskIndex, len(ev.inputs))
+}
+
+func (exec *mrExecutor) handleMapTaskInputs(event *evMapTaskInputs) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil || !instance.isActive() || instance.Stage != event.taskIndex {
+		return
+	}
+	if instance.State == StatePaused {
+		// the task is rendered when the instance is resumed
+		instance.TaskList[event.taskIndex].deferred = event
+		return
+	}
+	err := event.err
+	if err == nil {
+		err = exec.renderTask(p, instance, event.taskIndex, event.inputs)
+	}
+	if err != nil {
+		exec.post(&evTaskAbort{p, instance.ID, event.taskIndex, err.Error(), time.Now()})
+		return
+	}
+	exec.startTask(p, instance, event.taskIndex)
+}
@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapTaskInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMapTaskInputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"input", "1"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"input/part-00001", "input/part-00000", "input/_SUCCESS"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := "gs://bucket/a\n\n# comment\ngs://bucket/b\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "1", "manifest.txt"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &Spec{
+		Name:    "test",
+		Storage: "file://" + dir,
+		Tasks: []TaskSpec{
+			{Name: "prefix", Map: &MapSpec{Prefix: "file://" + dir + "/input/part-"}},
+			{Name: "manifest", Map: &MapSpec{Manifest: "manifest.txt"}},
+			{Name: "limit", Map: &MapSpec{Manifest: "manifest.txt", MaxShards: 1}},
+		},
+	}
+	instance := makeInstance(1)
+
+	inputs, err := mapTaskInputs(spec, instance, &spec.Tasks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"file://" + dir + "/input/part-00000",
+		"file://" + dir + "/input/part-00001",
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Errorf("expected %v, got %v", expected, inputs)
+	}
+
+	inputs, err = mapTaskInputs(spec, instance, &spec.Tasks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(inputs, []string{"gs://bucket/a", "gs://bucket/b"}) {
+		t.Error(inputs)
+	}
+
+	if _, err := mapTaskInputs(spec, instance, &spec.Tasks[2]); err == nil {
+		t.Error("expected shard limit error")
+	}
+}
+
+func TestMapTaskJobs(t *testing.T) {
+	spec := &Spec{
+		Name:      "test",
+		Namespace: "roque",
+		Tasks: []TaskSpec{
+			{
+				Name: "shard",
+				Map:  &MapSpec{Manifest: "gs://bucket/manifest"},
+				JobTemplate: JobTemplate{
+					Image: "shard",
+					Args:  []string{"-input={{.Shard.Input}}"},
+				},
+			},
+		},
+	}
+	defaultPipelineSpecValues(spec, "../../templates")
+
+	task, err := makeMapTask(spec, makeInstance(1), &spec.Tasks[0], []string{"gs://bucket/a", "gs://bucket/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.jobs) != 2 {
+		t.Fatal(len(task.jobs))
+	}
+	if name := task.jobs[1].Name; name != "test-shard-1-1" {
+		t.Error(name)
+	}
+	args := task.jobs[1].Spec.Template.Spec.Containers[0].Args
+	if args[len(args)-1] != "-input=gs://bucket/b" {
+		t.Error(args)
+	}
+}
+
+func TestMapTaskInputsEvent(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:  "test",
+			Tasks: []TaskSpec{{Name: "shard", Map: &MapSpec{Prefix: "gs://bucket/input/"}}},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, TaskList: []*Task{{}}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+	instance := p.Instances[0]
+
+	exec.handleMapTaskInputs(&evMapTaskInputs{p, 1, 0, nil, nil})
+	ev := nextEvent(t, exec)
+	if abort, ok := ev.(*evTaskAbort); !ok || !strings.Contains(abort.msg, "no inputs found") {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+
+	// inputs listed while the instance is paused are used on resume
+	instance.State = StatePaused
+	event := &evMapTaskInputs{p, 1, 0, []string{"gs://bucket/input/a"}, nil}
+	exec.handleMapTaskInputs(event)
+	if instance.TaskList[0].deferred != event || len(exec.events) != 0 {
+		t.Error("map inputs not deferred")
+	}
+
+	// inputs of an instance that is no longer active are dropped
+	instance.State = StateStopped
+	exec.handleMapTaskInputs(&evMapTaskInputs{p, 1, 0, nil, nil})
+	if len(exec.events) != 0 {
+		t.Error("unexpected event for a stopped instance")
+	}
+}
@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// OutputRecord describes an object produced by a task.
+type OutputRecord struct {
+	Task     string
+	URI      string
+	Size     int64
+	Checksum string `json:",omitempty"`
+	// Images are the container images of the jobs that produced the object.
+	Images []string `json:",omitempty"`
+	// CachedFrom is set when the object was copied from the outputs of
+	// another instance.
+	CachedFrom *InstanceRef `json:",omitempty"`
+	Time       time.Time
+}
+
+// LineageRecord identifies the instance that produced an object.
+type LineageRecord struct {
+	Pipeline string
+	Instance int
+	OutputRecord
+}
+
+// taskImages returns the container images of the rendered jobs of a task.
+func taskImages(task *Task) []string {
+	set := make(map[string]bool)
+	for _, job := range task.jobs {
+		for _, c := range job.Spec.Template.Spec.Containers {
+			set[c.Image] = true
+		}
+	}
+	var images []string
+	for image := range set {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// outputManifest lists the objects that match the outputs of a task. It
+// fails when an output does not match any object.
+func outputManifest(name, workDir string, outputs []string, images []string, cachedFrom *InstanceRef) ([]*OutputRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	now := time.Now()
+	var records []*OutputRecord
+	for _, output := range outputs {
+		objects, err := resolvePath(ctx, output, workDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("Task %s output %s not found", name, output)
+		}
+		for i := range objects {
+			checksum, err := objectChecksum(ctx, &objects[i])
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, &OutputRecord{
+				Task:       name,
+				URI:        objects[i].URI,
+				Size:       objects[i].Size,
+				Checksum:   checksum,
+				Images:     images,
+				CachedFrom: cachedFrom,
+				Time:       now,
+			})
+		}
+	}
+	return records, nil
+}
+
+// verifyOutputs checks the outputs of a completed task in the background.
+// The task completes once its output manifest is recorded.
+func (exec *mrExecutor) verifyOutputs(p *Pipeline, instance *Instance, stage int) {
+	spec := p.instanceSpec(instance)
+	taskSpec := &spec.Tasks[stage]
+	outputs, err := taskPaths(spec, instance, taskSpec, taskSpec.Outputs)
+	if err != nil {
+		exec.post(&evTaskAbort{p, instance.ID, stage, err.Error(), time.Now()})
+		return
+	}
+	task := instance.TaskList[stage]
+	images := taskImages(task)
+	cachedFrom := task.CachedFrom
+	workDir := p.instanceWorkDir(instance)
+	go func() {
+		records, err := outputManifest(taskSpec.Name, workDir, outputs, images, cachedFrom)
+		exec.events <- &evTaskOutputs{p, instance.ID, stage, records, err}
+	}()
+}
+
+type evTaskOutputs struct {
+	pipeline   *Pipeline
+	instanceID int
+	taskIndex  int
+	records    []*OutputRecord
+	err        error
+}
+
+func (ev *evTaskOutputs) eventType() smEventType { return eventTaskOutputs }
+func (ev *evTaskOutputs) String() string {
+	return fmt.Sprintf("TASK OUTPUTS %s:%d task:%d", ev.pipeline.Name, ev.instanceID, ev.taskIndex)
+}
+
+func (exec *mrExecutor) handleTaskOutputs(event *evTaskOutputs) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil || !instance.isActive() || instance.Stage != event.taskIndex {
+		return
+	}
+	if event.err != nil {
+		exec.post(&evTaskAbort{p, instance.ID, event.taskIndex, event.err.Error(), time.Now()})
+		return
+	}
+
+	// replace the records of a previous execution of the task
+	name := p.instanceSpec(instance).Tasks[event.taskIndex].Name
+	var outputs []*OutputRecord
+	for _, record := range instance.Outputs {
+		if record.Task != name {
+			outputs = append(outputs, record)
+		}
+	}
+	exec.Lock()
+	instance.Outputs = append(outputs, event.records...)
+	exec.Unlock()
+
+	instance.TaskList[event.taskIndex].outputsVerified = true
+	exec.post(&evTaskComplete{p, instance.ID, event.taskIndex, time.Now()})
+}
+
+// Lineage returns the instances that produced the object at uri.
+func (exec *mrExecutor) Lineage(uri string) []*LineageRecord {
+	exec.Lock()
+	defer exec.Unlock()
+	var pipelines []*Pipeline
+	for _, p := range exec.pipelines {
+		pipelines = append(pipelines, p)
+	}
+	return findLineage(pipelines, uri)
+}
+
+// findLineage returns the instances that recorded uri as an output.
+func findLineage(pipelines []*Pipeline, uri string) []*LineageRecord {
+	var result []*LineageRecord
+	for _, p := range pipelines {
+		for _, instance := range p.Instances {
+			for _, record := range instance.Outputs {
+				if record.URI == uri {
+					result = append(result, &LineageRecord{
+						Pipeline:     p.Name,
+						Instance:     instance.ID,
+						OutputRecord: *record,
+					})
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, exec *mrExecutor) smEvent {
+	select {
+	case ev := <-exec.events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	return nil
+}
+
+func TestTaskOutputs(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:    "test",
+			Storage: "mem://outputs",
+			Tasks: []TaskSpec{
+				{Name: "build", Outputs: []string{"model/*.bin"}},
+				{Name: "eval", Outputs: []string{"eval/report.txt"}},
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, TaskList: []*Task{{}, {}}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+	instance := p.Instances[0]
+	writeObject(t, "mem://outputs/1/model/weights.bin", "weights")
+
+	exec.handleTaskComplete(&evTaskComplete{p, 1, 0, time.Now()})
+	ev := nextEvent(t, exec)
+	outputs, ok := ev.(*evTaskOutputs)
+	if !ok {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+	exec.handleTaskOutputs(outputs)
+	if len(instance.Outputs) != 1 {
+		t.Fatalf("unexpected manifest %+v", instance.Outputs)
+	}
+	record := instance.Outputs[0]
+	if record.Task != "build" || record.URI != "mem://outputs/1/model/weights.bin" || record.Size != 7 || !strings.HasPrefix(record.Checksum, "sha256:") {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	ev = nextEvent(t, exec)
+	if _, ok := ev.(*evTaskComplete); !ok {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+	exec.handleTaskComplete(ev.(*evTaskComplete))
+	if instance.Stage != 1 {
+		t.Errorf("expected stage 1, got %d", instance.Stage)
+	}
+	nextEvent(t, exec) // task create
+
+	// the eval task does not produce its declared output
+	exec.handleTaskComplete(&evTaskComplete{p, 1, 1, time.Now()})
+	exec.handleTaskOutputs(nextEvent(t, exec).(*evTaskOutputs))
+	ev = nextEvent(t, exec)
+	if abort, ok := ev.(*evTaskAbort); !ok || !strings.Contains(abort.msg, "eval/report.txt not found") {
+		t.Errorf("unexpected event %s", ev.String())
+	}
+
+	lineage := findLineage([]*Pipeline{p}, "mem://outputs/1/model/weights.bin")
+	if len(lineage) != 1 || lineage[0].Pipeline != "test" || lineage[0].Instance != 1 || lineage[0].Task != "build" {
+		t.Errorf("unexpected lineage %+v", lineage)
+	}
+}
+
+func TestTaskCompleteStale(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:  "test",
+			Tasks: []TaskSpec{{Name: "build"}, {Name: "eval"}},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, Stage: 1, TaskList: []*Task{{}, {}}},
+			{ID: 2, State: StateStopped, TaskList: []*Task{{}, {}}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+
+	// a completion of an earlier execution of the instance
+	exec.handleTaskComplete(&evTaskComplete{p, 1, 0, time.Now()})
+	// a completion that arrives after the instance was stopped
+	exec.handleTaskComplete(&evTaskComplete{p, 2, 0, time.Now()})
+	if p.Instances[0].Stage != 1 || p.Instances[1].Stage != 0 || len(exec.events) != 0 {
+		t.Errorf("stale completion processed")
+	}
+}
@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+const (
+	paramTypeString = "string"
+	paramTypeInt    = "int"
+	paramTypeBool   = "bool"
+	paramTypeDate   = "date"
+
+	paramDateFormat = "2006-01-02"
+)
+
+// ParamSpec declares a run parameter of a pipeline.
+type ParamSpec struct {
+	Name string
+	// Type is one of string (default), int, bool or date (YYYY-MM-DD).
+	Type string `json:",omitempty"`
+	// Default value used when the parameter is not specified.
+	Default string `json:",omitempty"`
+	// Required parameters must be specified when an instance is created.
+	Required bool `json:",omitempty"`
+}
+
+func checkParamValue(param *ParamSpec, value string) error {
+	var err error
+	switch param.Type {
+	case "", paramTypeString:
+	case paramTypeInt:
+		_, err = strconv.Atoi(value)
+	case paramTypeBool:
+		_, err = strconv.ParseBool(value)
+	case paramTypeDate:
+		_, err = time.Parse(paramDateFormat, value)
+	default:
+		return fmt.Errorf("parameter %s: unknown type %s", param.Name, param.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("parameter %s: invalid %s value %q", param.Name, param.Type, value)
+	}
+	return nil
+}
+
+func validateParamSpecs(params []ParamSpec) error {
+	names := make(map[string]bool)
+	for i := range params {
+		param := &params[i]
+		if param.Name == "" {
+			return &validationError{"parameter name must be specified"}
+		}
+		if names[param.Name] {
+			return &validationError{fmt.Sprintf("duplicate parameter %s", param.Name)}
+		}
+		names[param.Name] = true
+		if param.Required && param.Default != "" {
+			return &validationError{fmt.Sprintf("required parameter %s cannot have a default", param.Name)}
+		}
+		switch param.Type {
+		case "", paramTypeString, paramTypeInt, paramTypeBool, paramTypeDate:
+		default:
+			return &validationError{fmt.Sprintf("parameter %s: unknown type %s", param.Name, param.Type)}
+		}
+		if param.Default != "" {
+			if err := checkParamValue(param, param.Default); err != nil {
+				return &validationError{err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+// validateParams checks the parameters of a new instance against the pipeline
+// declaration and returns the parameter values, including defaults.
+func validateParams(spec *Spec, params map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+	for k, v := range params {
+		values[k] = v
+	}
+
+	for i := range spec.Params {
+		param := &spec.Params[i]
+		value, ok := values[param.Name]
+		if !ok {
+			if param.Required {
+				return nil, fmt.Errorf("parameter %s is required", param.Name)
+			}
+			if param.Default == "" {
+				continue
+			}
+			value = param.Default
+			values[param.Name] = value
+		}
+		if err := checkParamValue(param, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for k := range values {
+		if getParamSpec(spec, k) == nil {
+			return nil, fmt.Errorf("unknown parameter %s", k)
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values, nil
+}
+
+func getParamSpec(spec *Spec, name string) *ParamSpec {
+	for i := range spec.Params {
+		if spec.Params[i].Name == name {
+			return &spec.Params[i]
+		}
+	}
+	return nil
+}
+
+// expandScheduleParams evaluates the parameter templates of a cron schedule
+// at the trigger time.
+func expandScheduleParams(sched *CronSchedule, now time.Time) (map[string]string, error) {
+	if sched == nil || len(sched.Params) == 0 {
+		return nil, nil
+	}
+	vars := struct{ Time time.Time }{now}
+	params := make(map[string]string)
+	for k, v := range sched.Params {
+		tmpl, err := template.New(k).Funcs(templateFuncs).Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, &vars); err != nil {
+			return nil, err
+		}
+		params[k] = buf.String()
+	}
+	return params, nil
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateParams(t *testing.T) {
+	spec := &Spec{
+		Params: []ParamSpec{
+			{Name: "date", Type: "date", Required: true},
+			{Name: "dataset", Default: "sitedata"},
+			{Name: "shards", Type: "int"},
+		},
+	}
+	if err := validateParamSpecs(spec.Params); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		params   map[string]string
+		expected map[string]string
+		valid    bool
+	}{
+		{
+			map[string]string{"date": "2017-03-01"},
+			map[string]string{"date": "2017-03-01", "dataset": "sitedata"},
+			true,
+		},
+		{
+			map[string]string{"date": "2017-03-01", "dataset": "news", "shards": "8"},
+			map[string]string{"date": "2017-03-01", "dataset": "news", "shards": "8"},
+			true,
+		},
+		{map[string]string{"dataset": "news"}, nil, false},
+		{map[string]string{"date": "03/01/2017"}, nil, false},
+		{map[string]string{"date": "2017-03-01", "shards": "x"}, nil, false},
+		{map[string]string{"date": "2017-03-01", "unknown": "x"}, nil, false},
+	}
+	for i, test := range testCases {
+		values, err := validateParams(spec, test.params)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("%d: expected %v, got %v", i, test.expected, values)
+		}
+	}
+}
+
+func TestScheduleParams(t *testing.T) {
+	sched := &CronSchedule{
+		Min:    "0",
+		Hour:   "1",
+		Params: map[string]string{"date": `{{.Time.Format "2006-01-02"}}`},
+	}
+	params, err := expandScheduleParams(sched, time.Date(2017, time.March, 1, 1, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["date"] != "2017-03-01" {
+		t.Error(params)
+	}
+}
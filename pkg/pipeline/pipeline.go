@@ -1,6 +1,11 @@
 package pipeline
 
-import "log"
+import (
+	"encoding/hex"
+	"strconv"
+
+	"k8s.io/client-go/kubernetes"
+)
 
 // ExecState defines the state of a job
 type ExecState string
@@ -10,6 +15,16 @@ const (
 	StateStopped ExecState = "Stopped"
 	// StateRunning means that the job is currently executing
 	StateRunning ExecState = "Running"
+	// StateComplete means that all the tasks of an instance executed successfully
+	StateComplete ExecState = "Complete"
+	// StateWaitingApproval means that the instance is waiting for an approval task
+	StateWaitingApproval ExecState = "WaitingApproval"
+	// StatePaused means that the jobs of the current task have been scaled down
+	StatePaused ExecState = "Paused"
+	// StateQueued means that the instance is waiting for an execution slot
+	StateQueued ExecState = "Queued"
+	// StatePending means that the current task is waiting for resources
+	StatePending ExecState = "Pending"
 )
 
 // Pipeline defines a data processing pipeline.
@@ -22,26 +37,112 @@ type Pipeline struct {
 	Config *Config   `json:"config"`
 	State  ExecState `json:"state"`
 
+	// Configs contains the configuration versions that have been loaded,
+	// indexed by the hex encoded config hash.
+	Configs map[string]*Config `json:"configs,omitempty"`
+	// History lists the configuration loads, oldest first.
+	History []*ConfigVersion `json:"history,omitempty"`
+
 	Instances []*Instance
+	Backfills []*Backfill `json:",omitempty"`
+	// LastID is the highest instance ID allocated. IDs are not reused after
+	// an instance is deleted, since its work directory may still exist.
+	LastID int `json:",omitempty"`
+}
+
+// instanceOptions contains the parameters used to create an instance.
+type instanceOptions struct {
+	trigger  *InstanceTrigger
+	params   map[string]string
+	backfill int
+	parent   *InstanceRef
+	// priority overrides the pipeline priority when set.
+	priority *int
 }
 
-func (p *Pipeline) createInstance() *Instance {
-	var max int
+func (p *Pipeline) createInstance(opts *instanceOptions) (*Instance, error) {
+	if opts == nil {
+		opts = &instanceOptions{}
+	}
+	params, err := validateParams(p.Config.Spec, opts.params)
+	if err != nil {
+		return nil, err
+	}
+
+	max := p.LastID
 	for _, instance := range p.Instances {
 		if instance.ID > max {
 			max = instance.ID
 		}
 	}
-	instance := makeInstance(max + 1)
+	p.LastID = max + 1
+	instance := makeInstance(p.LastID)
+	instance.Trigger = opts.trigger
+	instance.Params = params
+	instance.Backfill = opts.backfill
+	instance.Parent = opts.parent
+	instance.Priority = p.Config.Spec.Priority
+	instance.Hash = p.Config.Hash
+	if opts.priority != nil {
+		instance.Priority = *opts.priority
+	}
 
-	var err error
-	instance.TaskList, err = createTaskList(p.Config, instance.ID)
+	instance.TaskList, err = createTaskList(p.Config, instance)
 	if err != nil {
-		log.Println(err)
-		return nil
+		return nil, err
 	}
 	p.Instances = append(p.Instances, instance)
-	return instance
+	return instance, nil
+}
+
+func (p *Pipeline) instanceWorkDir(instance *Instance) string {
+	spec := p.instanceSpec(instance)
+	if spec.Storage == "" {
+		return ""
+	}
+	return pathJoin(spec.Storage, strconv.Itoa(instance.ID))
+}
+
+func configKey(hash []byte) string {
+	return hex.EncodeToString(hash)
+}
+
+// setConfig makes conf the current configuration of the pipeline. Previous
+// versions remain available to the instances that were started with them.
+func (p *Pipeline) setConfig(conf *Config) {
+	if p.Configs == nil {
+		p.Configs = make(map[string]*Config)
+	}
+	p.Configs[configKey(conf.Hash)] = conf
+	p.Config = conf
+}
+
+// instanceConfig returns the configuration version an instance was started
+// with, or the current configuration if that version is not known.
+func (p *Pipeline) instanceConfig(instance *Instance) *Config {
+	if conf, ok := p.Configs[configKey(instance.Hash)]; ok {
+		return conf
+	}
+	return p.Config
+}
+
+func (p *Pipeline) instanceSpec(instance *Instance) *Spec {
+	return p.instanceConfig(instance).Spec
+}
+
+// upgradeInstance moves an instance to the current configuration. Must be
+// called with the lock held.
+func (p *Pipeline) upgradeInstance(instance *Instance) error {
+	taskList, err := createTaskList(p.Config, instance)
+	if err != nil {
+		return err
+	}
+	instance.Hash = p.Config.Hash
+	instance.TaskList = taskList
+	if instance.Stage >= len(taskList) {
+		instance.Stage = 0
+	}
+	return nil
 }
 
 func (p *Pipeline) getInstance(id int) *Instance {
@@ -53,8 +154,8 @@ func (p *Pipeline) getInstance(id int) *Instance {
 	return nil
 }
 
-func (p *Pipeline) deleteInstance(target *Instance) {
-	p.deleteInstanceResources(nil, target)
+func (p *Pipeline) deleteInstance(k8sClient kubernetes.Interface, target *Instance) {
+	p.deleteInstanceResources(k8sClient, target)
 
 	for i, instance := range p.Instances {
 		if instance == target {
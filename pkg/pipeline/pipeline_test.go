@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"testing"
+)
+
+func TestConfigPinning(t *testing.T) {
+	v1 := &Config{
+		Hash: []byte{1},
+		Spec: &Spec{
+			Name:    "example",
+			Storage: "gs://bucket/v1",
+			Tasks:   []TaskSpec{{Name: "a", Sensor: &SensorSpec{Interval: "1m"}}},
+		},
+	}
+	v2 := &Config{
+		Hash: []byte{2},
+		Spec: &Spec{
+			Name:    "example",
+			Storage: "gs://bucket/v2",
+			Tasks: []TaskSpec{
+				{Name: "a", Sensor: &SensorSpec{Interval: "1m"}},
+				{Name: "b", Sensor: &SensorSpec{Interval: "1m"}},
+			},
+		},
+	}
+
+	p := &Pipeline{Name: "example"}
+	p.setConfig(v1)
+	instance, err := p.createInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.setConfig(v2)
+	if len(p.Configs) != 2 {
+		t.Errorf("expected 2 config versions, got %d", len(p.Configs))
+	}
+	if spec := p.instanceSpec(instance); spec != v1.Spec {
+		t.Error("instance should execute with the config it started with")
+	}
+	if dir := p.instanceWorkDir(instance); dir != "gs://bucket/v1/1" {
+		t.Error(dir)
+	}
+
+	if err := p.upgradeInstance(instance); err != nil {
+		t.Fatal(err)
+	}
+	if spec := p.instanceSpec(instance); spec != v2.Spec {
+		t.Error("instance not upgraded")
+	}
+	if len(instance.TaskList) != 2 {
+		t.Error(len(instance.TaskList))
+	}
+}
+
+func TestConfigInstallEvent(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	v1 := &Config{Hash: []byte{1}, Spec: &Spec{Name: "example"}}
+	p := &Pipeline{Name: "example", URI: "gs://bucket/example.yaml"}
+	p.setConfig(v1)
+	if _, err := p.createInstance(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := &Config{Hash: []byte{2}, Spec: &Spec{Name: "example"}}
+	exec.handleConfigInstall(&evConfigInstall{p, v2, p.URI, "alice"})
+	if p.Config != v2 || len(p.Configs) != 2 {
+		t.Errorf("config not installed: %d versions", len(p.Configs))
+	}
+	if len(p.History) != 1 || p.History[0].User != "alice" {
+		t.Errorf("unexpected history %+v", p.History)
+	}
+}
@@ -0,0 +1,286 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// podLogTailLines is the number of lines saved from the log of a
+	// terminated pod.
+	podLogTailLines = 200
+	// podLogMaxBytes limits the size of a saved pod log.
+	podLogMaxBytes = 64 * 1024
+)
+
+// PodRecord describes a pod that executed a job of a task.
+type PodRecord struct {
+	Name    string
+	Job     string          `json:",omitempty"`
+	Phase   api_v1.PodPhase `json:",omitempty"`
+	Reason  string          `json:",omitempty"`
+	Message string          `json:",omitempty"`
+	// ExitCode is the exit code of the first failed container, or of the
+	// first container when all succeed.
+	ExitCode *int32 `json:",omitempty"`
+	// LogURI is the work dir object that contains the tail of the log of a
+	// terminated pod.
+	LogURI string `json:",omitempty"`
+	// Log is the tail of the log of a terminated pod when the pipeline has
+	// no storage.
+	Log      string `json:",omitempty"`
+	LogError string `json:",omitempty"`
+
+	// logPending is set while the log is being saved and logDone once it
+	// is recorded.
+	logPending bool
+	logDone    bool
+}
+
+// terminated returns true when the pod containers are no longer executing.
+func (record *PodRecord) terminated() bool {
+	return record.Phase == api_v1.PodSucceeded || record.Phase == api_v1.PodFailed
+}
+
+// logSaved returns true once the log of a terminated pod is recorded.
+func (record *PodRecord) logSaved() bool {
+	return record.logDone || record.LogURI != "" || record.Log != "" || record.LogError != ""
+}
+
+func (record *PodRecord) update(pod *api_v1.Pod) {
+	record.Phase = pod.Status.Phase
+	record.Reason = pod.Status.Reason
+	record.Message = pod.Status.Message
+
+	var state *api_v1.ContainerStateTerminated
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil {
+			continue
+		}
+		if state == nil || (state.ExitCode == 0 && terminated.ExitCode != 0) {
+			state = terminated
+		}
+	}
+	if state == nil {
+		return
+	}
+	exitCode := state.ExitCode
+	record.ExitCode = &exitCode
+	if record.Reason == "" {
+		record.Reason = state.Reason
+	}
+	if record.Message == "" {
+		record.Message = state.Message
+	}
+}
+
+// podTaskIndex returns the stage of the task that created a pod, or -1.
+func podTaskIndex(p *Pipeline, instance *Instance, pod *api_v1.Pod) int {
+	if name := pod.Labels["job-name"]; name != "" {
+		for i, task := range instance.TaskList {
+			if task.getJobByName(name) != nil {
+				return i
+			}
+		}
+	}
+	return taskIndexByName(p.instanceSpec(instance), pod.Labels["task"])
+}
+
+// taskIndexByName returns the stage of the named task, or -1.
+func taskIndexByName(spec *Spec, name string) int {
+	for i := range spec.Tasks {
+		if spec.Tasks[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// podLogDir returns the prefix of the work dir objects used to save pod logs.
+func podLogDir(workDir string) string {
+	return pathJoin(workDir, "logs/")
+}
+
+// podLogURI returns the work dir object used to save the log of a pod.
+func podLogURI(workDir, task, pod string) string {
+	return podLogDir(workDir) + task + "/" + pod + ".log"
+}
+
+// sortedPods returns copies of the pod records of a task ordered by name.
+func sortedPods(task *Task) []PodRecord {
+	var names []string
+	for name := range task.Pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	records := make([]PodRecord, 0, len(names))
+	for _, name := range names {
+		records = append(records, *task.Pods[name])
+	}
+	return records
+}
+
+// TaskPods returns the pod records of a task ordered by name. The records
+// are copied under the lock since the executor updates them.
+func (exec *mrExecutor) TaskPods(p *Pipeline, instance *Instance, stage int) []PodRecord {
+	exec.Lock()
+	defer exec.Unlock()
+	if stage < 0 || stage >= len(instance.TaskList) {
+		return nil
+	}
+	return sortedPods(instance.TaskList[stage])
+}
+
+// podLogs opens the log of a pod in the kubernetes api-server.
+func (exec *mrExecutor) podLogs(namespace, name string, opts *api_v1.PodLogOptions) (io.ReadCloser, error) {
+	if exec.k8sClient == nil {
+		return nil, fmt.Errorf("No kubernetes client")
+	}
+	return exec.k8sClient.Core().Pods(namespace).GetLogs(name, opts).Stream()
+}
+
+// PodLogs opens the log of a pod of an instance. When follow is set the
+// log is streamed while the pod executes.
+func (exec *mrExecutor) PodLogs(p *Pipeline, instance *Instance, pod string, follow bool) (io.ReadCloser, error) {
+	opts := &api_v1.PodLogOptions{Follow: follow}
+	if !follow {
+		tail := int64(podLogTailLines)
+		opts.TailLines = &tail
+	}
+	return exec.podLogs(p.instanceSpec(instance).Namespace, pod, opts)
+}
+
+// readPodLog reads the tail of the log of a pod and, when uri is set, writes
+// it to storage.
+func readPodLog(rd io.Reader, uri string) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(rd, podLogMaxBytes))
+	if err != nil || uri == "" {
+		return data, err
+	}
+	wr, err := newFileWriter(uri)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := wr.Write(data); err != nil {
+		wr.Close()
+		return nil, err
+	}
+	return data, wr.Close()
+}
+
+// savePodLog saves the tail of the log of a terminated pod in the background.
+// The log is written to the work dir of the instance or, when the pipeline
+// has no storage, kept in the pod record.
+func (exec *mrExecutor) savePodLog(p *Pipeline, instance *Instance, stage int, pod string) {
+	spec := p.instanceSpec(instance)
+	var uri string
+	if workDir := p.instanceWorkDir(instance); workDir != "" {
+		uri = podLogURI(workDir, spec.Tasks[stage].Name, pod)
+	}
+	tail := int64(podLogTailLines)
+	limit := int64(podLogMaxBytes)
+	opts := &api_v1.PodLogOptions{TailLines: &tail, LimitBytes: &limit}
+	go func() {
+		var data []byte
+		rd, err := exec.podLogs(spec.Namespace, pod, opts)
+		if err == nil {
+			data, err = readPodLog(rd, uri)
+			rd.Close()
+		}
+		exec.events <- &evPodLog{p, instance.ID, stage, pod, uri, string(data), err}
+	}()
+}
+
+type evPodStatus struct {
+	pipeline   *Pipeline
+	instanceID int
+	pod        *api_v1.Pod
+}
+
+func (ev *evPodStatus) eventType() smEventType { return eventPodStatus }
+func (ev *evPodStatus) String() string {
+	return fmt.Sprintf("POD STATUS %s:%d %s %s", ev.pipeline.Name, ev.instanceID, ev.pod.Name, ev.pod.Status.Phase)
+}
+
+func (exec *mrExecutor) handlePodStatus(event *evPodStatus) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil {
+		return
+	}
+	pod := event.pod
+	stage := podTaskIndex(p, instance, pod)
+	if stage < 0 || stage >= len(instance.TaskList) {
+		log.Printf("unexpected event for pod %s", pod.Name)
+		return
+	}
+
+	// the records are read by the API under the lock
+	task := instance.TaskList[stage]
+	exec.Lock()
+	defer exec.Unlock()
+	if task.Pods == nil {
+		task.Pods = make(map[string]*PodRecord)
+	}
+	record := task.Pods[pod.Name]
+	if record == nil {
+		record = &PodRecord{Name: pod.Name, Job: pod.Labels["job-name"]}
+		task.Pods[pod.Name] = record
+	}
+	record.update(pod)
+
+	if !record.terminated() || record.logSaved() || record.logPending {
+		return
+	}
+	if record.Phase == api_v1.PodFailed {
+		log.Printf("%s:%d pod %s failed: %s", p.Name, instance.ID, pod.Name, record.Reason)
+	}
+	record.logPending = true
+	exec.savePodLog(p, instance, stage, pod.Name)
+}
+
+type evPodLog struct {
+	pipeline   *Pipeline
+	instanceID int
+	taskIndex  int
+	pod        string
+	uri        string
+	log        string
+	err        error
+}
+
+func (ev *evPodLog) eventType() smEventType { return eventPodLog }
+func (ev *evPodLog) String() string {
+	return fmt.Sprintf("POD LOG %s:%d %s", ev.pipeline.Name, ev.instanceID, ev.pod)
+}
+
+func (exec *mrExecutor) handlePodLog(event *evPodLog) {
+	instance := event.pipeline.getInstance(event.instanceID)
+	if instance == nil || event.taskIndex >= len(instance.TaskList) {
+		return
+	}
+	record := instance.TaskList[event.taskIndex].Pods[event.pod]
+	if record == nil {
+		return
+	}
+	exec.Lock()
+	defer exec.Unlock()
+	record.logPending = false
+	record.logDone = true
+	if event.err != nil {
+		log.Printf("%s:%d pod %s log: %v", event.pipeline.Name, instance.ID, event.pod, event.err)
+		record.LogError = event.err.Error()
+		return
+	}
+	if event.uri != "" {
+		record.LogURI = event.uri
+	} else {
+		record.Log = event.log
+	}
+}
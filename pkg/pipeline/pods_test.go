@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+	batch_v1 "k8s.io/client-go/pkg/apis/batch/v1"
+)
+
+func TestPodStatus(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	job := &batch_v1.Job{}
+	job.Name = "test-train-1"
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name:  "test",
+			Tasks: []TaskSpec{{Name: "prepare"}, {Name: "train"}},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, Stage: 1, TaskList: []*Task{{}, {jobs: []*batch_v1.Job{job}}}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+	instance := p.Instances[0]
+
+	pod := &api_v1.Pod{}
+	pod.Name = "test-train-1-abcde"
+	pod.Labels = map[string]string{"pipeline": "test", "id": "1", "task": "train", "job-name": "test-train-1"}
+	pod.Status.Phase = api_v1.PodRunning
+	exec.handlePodStatus(&evPodStatus{p, 1, pod})
+
+	record := instance.TaskList[1].Pods[pod.Name]
+	if record == nil || record.Job != "test-train-1" || record.Phase != api_v1.PodRunning || record.ExitCode != nil {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if len(exec.events) != 0 {
+		t.Errorf("unexpected event for a running pod")
+	}
+
+	pod.Status.Phase = api_v1.PodFailed
+	pod.Status.ContainerStatuses = []api_v1.ContainerStatus{
+		{Name: "sidecar", State: api_v1.ContainerState{Terminated: &api_v1.ContainerStateTerminated{}}},
+		{Name: "train", State: api_v1.ContainerState{Terminated: &api_v1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}},
+	}
+	exec.handlePodStatus(&evPodStatus{p, 1, pod})
+	if record.ExitCode == nil || *record.ExitCode != 137 || record.Reason != "OOMKilled" {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	// without a kubernetes client the log can't be fetched
+	ev := nextEvent(t, exec)
+	podLog, ok := ev.(*evPodLog)
+	if !ok {
+		t.Fatalf("unexpected event %s", ev.String())
+	}
+	exec.handlePodLog(podLog)
+	if record.LogError == "" || !record.logSaved() {
+		t.Errorf("unexpected record %+v", record)
+	}
+	exec.handlePodStatus(&evPodStatus{p, 1, pod})
+	if len(exec.events) != 0 {
+		t.Errorf("log fetched more than once")
+	}
+
+	exec.handlePodLog(&evPodLog{p, 1, 1, pod.Name, "", "killed\n", nil})
+	if record.Log != "killed\n" {
+		t.Errorf("unexpected log %q", record.Log)
+	}
+
+	// pods of map tasks are matched by the task label
+	other := &api_v1.Pod{}
+	other.Name = "prepare-xyz"
+	other.Labels = map[string]string{"task": "prepare"}
+	exec.handlePodStatus(&evPodStatus{p, 1, other})
+	if instance.TaskList[0].Pods[other.Name] == nil {
+		t.Error("pod not tracked by task label")
+	}
+	pods := exec.TaskPods(p, instance, 1)
+	if len(pods) != 1 || pods[0].Name != record.Name || pods[0].Log != "killed\n" {
+		t.Fatalf("unexpected pods %+v", pods)
+	}
+	// the API is served copies of the records
+	pods[0].Log = ""
+	if record.Log != "killed\n" {
+		t.Error("pod record shared with the caller")
+	}
+	if pods := exec.TaskPods(p, instance, 2); pods != nil {
+		t.Errorf("unexpected pods %+v", pods)
+	}
+}
+
+func TestReadPodLog(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	uri := podLogURI("mem://logs/1", "train", "train-abcde")
+	if uri != "mem://logs/1/logs/train/train-abcde.log" {
+		t.Errorf("unexpected uri %s", uri)
+	}
+
+	data, err := readPodLog(strings.NewReader("line 1\nline 2\n"), uri)
+	if err != nil || string(data) != "line 1\nline 2\n" {
+		t.Fatalf("unexpected result %q %v", data, err)
+	}
+	rd, err := newFileReader(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	if saved, _ := ioutil.ReadAll(rd); string(saved) != string(data) {
+		t.Errorf("unexpected saved log %q", saved)
+	}
+
+	data, _ = readPodLog(strings.NewReader(strings.Repeat("x", podLogMaxBytes+10)), "")
+	if len(data) != podLogMaxBytes {
+		t.Errorf("log not truncated: %d", len(data))
+	}
+}
@@ -0,0 +1,253 @@
+package pipeline
+
+import (
+	"log"
+	"time"
+)
+
+// QueueEntry is an instance waiting for a free execution slot.
+type QueueEntry struct {
+	Pipeline string
+	ID       int
+	Stage    int
+	Priority int `json:",omitempty"`
+	// Preempted entries are paused instances that resume when a slot is free.
+	Preempted bool `json:",omitempty"`
+	Time      time.Time
+}
+
+// preemptTarget is a running instance selected to be paused in favor of a
+// higher priority instance.
+type preemptTarget struct {
+	p        *Pipeline
+	instance *Instance
+}
+
+// holdsSlot returns true when the instance counts against the concurrency
+// limits. Instances that wait on an approval or on the child instance of a
+// sub-pipeline task do not hold a slot, so that the child can be admitted.
+func (p *Pipeline) holdsSlot(instance *Instance) bool {
+	switch instance.State {
+	case StateRunning, StatePending:
+		tasks := p.instanceSpec(instance).Tasks
+		return instance.Stage >= len(tasks) || tasks[instance.Stage].Pipeline == nil
+	}
+	return false
+}
+
+func (p *Pipeline) runningInstances() int {
+	var count int
+	for _, instance := range p.Instances {
+		if p.holdsSlot(instance) {
+			count++
+		}
+	}
+	return count
+}
+
+// SetMaxRunningInstances limits the number of instances running across all
+// pipelines (0 means no limit).
+func (exec *mrExecutor) SetMaxRunningInstances(n int) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.maxRunning = n
+}
+
+// SetPreemption enables pausing lower priority instances when the global
+// limit is reached.
+func (exec *mrExecutor) SetPreemption(enabled bool) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.preemption = enabled
+}
+
+// Queue returns the instances waiting to execute, in the order in which
+// they will be started.
+func (exec *mrExecutor) Queue() []QueueEntry {
+	exec.Lock()
+	defer exec.Unlock()
+	queue := make([]QueueEntry, len(exec.queue))
+	copy(queue, exec.queue)
+	return queue
+}
+
+// enqueue inserts an entry after all the entries with the same or higher
+// priority. Must be called with the lock held.
+func (exec *mrExecutor) enqueue(entry QueueEntry) {
+	i := len(exec.queue)
+	for j, e := range exec.queue {
+		if e.Priority < entry.Priority {
+			i = j
+			break
+		}
+	}
+	exec.queue = append(exec.queue, QueueEntry{})
+	copy(exec.queue[i+1:], exec.queue[i:])
+	exec.queue[i] = entry
+}
+
+func (exec *mrExecutor) pipelineCanRun(p *Pipeline) bool {
+	max := p.Config.Spec.MaxConcurrentInstances
+	return max == 0 || p.runningInstances() < max
+}
+
+func (exec *mrExecutor) globalCanRun() bool {
+	if exec.maxRunning == 0 {
+		return true
+	}
+	var total int
+	for _, pipeline := range exec.pipelines {
+		total += pipeline.runningInstances()
+	}
+	return total < exec.maxRunning
+}
+
+// canRun checks the pipeline and global concurrency limits. Must be called
+// with the lock held.
+func (exec *mrExecutor) canRun(p *Pipeline) bool {
+	return exec.pipelineCanRun(p) && exec.globalCanRun()
+}
+
+// preemptionCandidate selects the lowest priority running instance with a
+// priority lower than the one specified. Only instances executing jobs can
+// be paused.
+func (exec *mrExecutor) preemptionCandidate(priority int) *preemptTarget {
+	var target *preemptTarget
+	for _, p := range exec.pipelines {
+		for _, instance := range p.Instances {
+			if instance.State != StateRunning || instance.Priority >= priority {
+				continue
+			}
+			if !p.instanceSpec(instance).Tasks[instance.Stage].hasJobs() {
+				continue
+			}
+			if target == nil || instance.Priority < target.instance.Priority {
+				target = &preemptTarget{p, instance}
+			}
+		}
+	}
+	return target
+}
+
+// admitInstance returns true if the instance can start immediately. Otherwise
+// the instance is added to the queue. When preemption is enabled, the
+// instance that must be paused to make room for this one is returned.
+func (exec *mrExecutor) admitInstance(p *Pipeline, instance *Instance, stage int) (bool, *preemptTarget) {
+	exec.Lock()
+	defer exec.Unlock()
+	if exec.canRun(p) {
+		return true, nil
+	}
+
+	if exec.preemption && exec.pipelineCanRun(p) {
+		if target := exec.preemptionCandidate(instance.Priority); target != nil {
+			target.instance.State = StatePaused
+			target.instance.Preempted = true
+			exec.enqueue(QueueEntry{
+				Pipeline:  target.p.Name,
+				ID:        target.instance.ID,
+				Stage:     target.instance.Stage,
+				Priority:  target.instance.Priority,
+				Preempted: true,
+				Time:      time.Now(),
+			})
+			return true, target
+		}
+	}
+
+	instance.State = StateQueued
+	instance.Stage = stage
+	exec.enqueue(QueueEntry{
+		Pipeline: p.Name,
+		ID:       instance.ID,
+		Stage:    stage,
+		Priority: instance.Priority,
+		Time:     time.Now(),
+	})
+	return false, nil
+}
+
+// dequeueInstance removes an instance from the queue.
+func (exec *mrExecutor) dequeueInstance(p *Pipeline, instanceID int) {
+	exec.Lock()
+	defer exec.Unlock()
+	for i, entry := range exec.queue {
+		if entry.Pipeline == p.Name && entry.ID == instanceID {
+			exec.queue = append(exec.queue[:i], exec.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatchQueue starts the queued instances for which there is capacity.
+// Entries blocked by a pipeline limit do not block other pipelines.
+func (exec *mrExecutor) dispatchQueue() {
+	type startEntry struct {
+		p         *Pipeline
+		instance  *Instance
+		stage     int
+		preempted bool
+	}
+	var start []startEntry
+
+	exec.Lock()
+	var remaining []QueueEntry
+	for _, entry := range exec.queue {
+		p := exec.pipelines[entry.Pipeline]
+		if p == nil {
+			continue
+		}
+		instance := p.getInstance(entry.ID)
+		if instance == nil {
+			continue
+		}
+		if entry.Preempted {
+			if instance.State != StatePaused || !instance.Preempted {
+				continue
+			}
+		} else if instance.State != StateQueued {
+			continue
+		}
+		if !exec.canRun(p) {
+			remaining = append(remaining, entry)
+			continue
+		}
+		// mark the instance as running so that it counts against the limits
+		instance.State = StateRunning
+		instance.Preempted = false
+		start = append(start, startEntry{p, instance, entry.Stage, entry.Preempted})
+	}
+	exec.queue = remaining
+	exec.Unlock()
+
+	for _, s := range start {
+		log.Printf("%s:%d dequeued", s.p.Name, s.instance.ID)
+		if s.preempted {
+			exec.resumeInstance(s.p, s.instance)
+		} else {
+			exec.runInstance(s.p, s.instance, s.stage)
+		}
+	}
+}
+
+// restoreQueue rebuilds the queue from the instance state after a restart.
+// Must be called with the lock held.
+func (exec *mrExecutor) restoreQueue() {
+	exec.queue = nil
+	for _, p := range exec.pipelines {
+		for _, instance := range p.Instances {
+			preempted := instance.State == StatePaused && instance.Preempted
+			if instance.State != StateQueued && !preempted {
+				continue
+			}
+			exec.enqueue(QueueEntry{
+				Pipeline:  p.Name,
+				ID:        instance.ID,
+				Stage:     instance.Stage,
+				Priority:  instance.Priority,
+				Preempted: preempted,
+				Time:      time.Now(),
+			})
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestQueueAdmission(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	limited := &Pipeline{
+		Name:   "limited",
+		Config: &Config{Spec: &Spec{Name: "limited", MaxConcurrentInstances: 1}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning},
+			{ID: 2},
+		},
+	}
+	other := &Pipeline{
+		Name:   "other",
+		Config: &Config{Spec: &Spec{Name: "other"}},
+		Instances: []*Instance{
+			{ID: 1},
+			{ID: 2},
+		},
+	}
+	exec.pipelines[limited.Name] = limited
+	exec.pipelines[other.Name] = other
+
+	if ok, _ := exec.admitInstance(limited, limited.Instances[1], 0); ok {
+		t.Error("pipeline limit not enforced")
+	}
+	if limited.Instances[1].State != StateQueued {
+		t.Error(limited.Instances[1].State)
+	}
+
+	exec.SetMaxRunningInstances(2)
+	if ok, _ := exec.admitInstance(other, other.Instances[0], 0); !ok {
+		t.Error("instance should be admitted")
+	}
+	other.Instances[0].State = StateRunning
+	if ok, _ := exec.admitInstance(other, other.Instances[1], 1); ok {
+		t.Error("global limit not enforced")
+	}
+
+	queue := exec.Queue()
+	if len(queue) != 2 {
+		t.Fatal(queue)
+	}
+	if queue[0].Pipeline != "limited" || queue[1].Pipeline != "other" || queue[1].Stage != 1 {
+		t.Errorf("unexpected queue order %+v", queue)
+	}
+
+	exec.dequeueInstance(limited, 2)
+	if queue := exec.Queue(); len(queue) != 1 || queue[0].Pipeline != "other" {
+		t.Errorf("unexpected queue %+v", queue)
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines:  make(map[string]*Pipeline),
+		events:     make(chan smEvent, 16),
+		maxRunning: 1,
+	}
+	p := &Pipeline{
+		Name: "example",
+		Config: &Config{Spec: &Spec{
+			Name:  "example",
+			Tasks: []TaskSpec{{Name: "run", JobTemplate: JobTemplate{Image: "run"}}},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning},
+			{ID: 2},
+			{ID: 3, Priority: 10},
+			{ID: 4},
+			{ID: 5, Priority: 20},
+		},
+	}
+	exec.pipelines[p.Name] = p
+
+	for _, instance := range p.Instances[1:4] {
+		if ok, _ := exec.admitInstance(p, instance, 0); ok {
+			t.Fatalf("instance %d admitted", instance.ID)
+		}
+	}
+	var order []int
+	for _, entry := range exec.Queue() {
+		order = append(order, entry.ID)
+	}
+	if len(order) != 3 || order[0] != 3 || order[1] != 2 || order[2] != 4 {
+		t.Errorf("unexpected queue order %v", order)
+	}
+
+	exec.SetPreemption(true)
+	ok, target := exec.admitInstance(p, p.Instances[4], 0)
+	if !ok || target == nil || target.instance.ID != 1 {
+		t.Fatalf("expected instance 1 to be preempted: %t %v", ok, target)
+	}
+	if !p.Instances[0].Preempted || p.Instances[0].State != StatePaused {
+		t.Error(p.Instances[0].State)
+	}
+	queue := exec.Queue()
+	if len(queue) != 4 || queue[0].ID != 3 || !queue[3].Preempted {
+		t.Errorf("unexpected queue %+v", queue)
+	}
+}
+
+func TestQueueDispatch(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+	p := &Pipeline{
+		Name: "example",
+		Config: &Config{Spec: &Spec{
+			Name:  "example",
+			Tasks: []TaskSpec{{Name: "run", JobTemplate: JobTemplate{Image: "run"}}},
+		}},
+	}
+	exec.pipelines[p.Name] = p
+	for i := 1; i <= 20; i++ {
+		instance := &Instance{ID: i, State: StateQueued, TaskList: []*Task{{}}}
+		p.Instances = append(p.Instances, instance)
+		exec.enqueue(QueueEntry{Pipeline: p.Name, ID: i})
+	}
+
+	// starting more instances than the events channel holds must not block
+	done := make(chan struct{})
+	go func() {
+		exec.dispatchQueue()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatchQueue blocked")
+	}
+	if len(exec.events)+len(exec.overflow) != 20 || len(exec.Queue()) != 0 {
+		t.Errorf("unexpected events %d, overflow %d", len(exec.events), len(exec.overflow))
+	}
+}
+
+func TestQueueResume(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+		k8sClient: fake.NewSimpleClientset(),
+	}
+	p := &Pipeline{
+		Name: "example",
+		Config: &Config{Spec: &Spec{
+			Name:                   "example",
+			MaxConcurrentInstances: 1,
+			Tasks:                  []TaskSpec{{Name: "run", JobTemplate: JobTemplate{Image: "run"}}},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StatePaused, TaskList: []*Task{{}}},
+			{ID: 2, State: StateRunning, TaskList: []*Task{{}}},
+		},
+	}
+	exec.pipelines[p.Name] = p
+	paused := p.Instances[0]
+
+	// the resume waits for the running instance to release its slot
+	exec.handleInstancePause(&evInstancePause{p, 1, true})
+	if paused.State != StatePaused || !paused.Preempted {
+		t.Fatalf("unexpected state %s", paused.State)
+	}
+	if queue := exec.Queue(); len(queue) != 1 || queue[0].ID != 1 || !queue[0].Preempted {
+		t.Fatalf("unexpected queue %+v", queue)
+	}
+
+	p.Instances[1].State = StateComplete
+	exec.dispatchQueue()
+	if paused.State != StateRunning || paused.Preempted || len(exec.Queue()) != 0 {
+		t.Errorf("instance not resumed: %s %+v", paused.State, exec.Queue())
+	}
+}
+
+func TestQueueSubPipeline(t *testing.T) {
+	exec := &mrExecutor{
+		pipelines:  make(map[string]*Pipeline),
+		events:     make(chan smEvent, 16),
+		maxRunning: 1,
+	}
+	parent := &Pipeline{
+		Name: "parent",
+		Config: &Config{Spec: &Spec{
+			Name: "parent",
+			Tasks: []TaskSpec{
+				{Name: "prepare", JobTemplate: JobTemplate{Image: "prepare"}},
+				{Name: "child", Pipeline: &SubPipelineSpec{Name: "child"}},
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, Stage: 1, TaskList: []*Task{{}, {}}},
+		},
+	}
+	child := &Pipeline{
+		Name: "child",
+		Config: &Config{Spec: &Spec{
+			Name:  "child",
+			Tasks: []TaskSpec{{Name: "run", JobTemplate: JobTemplate{Image: "run"}}},
+		}},
+		Instances: []*Instance{
+			{ID: 1, TaskList: []*Task{{}}},
+		},
+	}
+	exec.pipelines[parent.Name] = parent
+	exec.pipelines[child.Name] = child
+
+	// the parent waits on the child and does not hold the only slot
+	if admitted, _ := exec.admitInstance(child, child.Instances[0], 0); !admitted {
+		t.Fatal("child instance queued behind its parent")
+	}
+	child.Instances[0].State = StateRunning
+
+	// an instance that waits for an approval does not hold a slot either
+	parent.Instances[0].State = StateWaitingApproval
+	child.Instances[0].State = StateComplete
+	if admitted, _ := exec.admitInstance(child, &Instance{ID: 2}, 0); !admitted {
+		t.Error("instance queued behind an approval")
+	}
+
+	parent.Instances[0].Stage = 0
+	parent.Instances[0].State = StateRunning
+	if admitted, _ := exec.admitInstance(child, &Instance{ID: 3}, 0); admitted {
+		t.Error("instance admitted beyond the limit")
+	}
+}
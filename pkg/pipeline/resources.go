@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// taskRequests adds up the resources requested by the jobs of a task at
+// their configured parallelism. Container limits are used when a container
+// does not specify requests.
+func taskRequests(task *Task) api_v1.ResourceList {
+	total := make(api_v1.ResourceList)
+	for _, job := range task.jobs {
+		var parallelism int32 = 1
+		if job.Spec.Parallelism != nil {
+			parallelism = *job.Spec.Parallelism
+		}
+		for _, container := range job.Spec.Template.Spec.Containers {
+			resources := container.Resources.Requests
+			if len(resources) == 0 {
+				resources = container.Resources.Limits
+			}
+			for name, qty := range resources {
+				sum := total[name]
+				for i := int32(0); i < parallelism; i++ {
+					sum.Add(qty)
+				}
+				total[name] = sum
+			}
+		}
+	}
+	return total
+}
+
+// taskLimits adds up the resource limits of the jobs of a task at their
+// configured parallelism.
+func taskLimits(task *Task) api_v1.ResourceList {
+	total := make(api_v1.ResourceList)
+	for _, job := range task.jobs {
+		var parallelism int32 = 1
+		if job.Spec.Parallelism != nil {
+			parallelism = *job.Spec.Parallelism
+		}
+		for _, container := range job.Spec.Template.Spec.Containers {
+			for name, qty := range container.Resources.Limits {
+				sum := total[name]
+				for i := int32(0); i < parallelism; i++ {
+					sum.Add(qty)
+				}
+				total[name] = sum
+			}
+		}
+	}
+	return total
+}
+
+// quotaUsage maps the resources requested by a task to the quota resource
+// names that account for them: requests count against "<name>" and
+// "requests.<name>", limits against "limits.<name>".
+func quotaUsage(requests, limits api_v1.ResourceList) api_v1.ResourceList {
+	usage := make(api_v1.ResourceList)
+	for name, qty := range requests {
+		usage[name] = qty
+		usage["requests."+name] = qty
+	}
+	for name, qty := range limits {
+		usage["limits."+name] = qty
+	}
+	return usage
+}
+
+func sortedResourceNames(resources api_v1.ResourceList) []api_v1.ResourceName {
+	var keys []string
+	for name := range resources {
+		keys = append(keys, string(name))
+	}
+	sort.Strings(keys)
+	names := make([]api_v1.ResourceName, len(keys))
+	for i, k := range keys {
+		names[i] = api_v1.ResourceName(k)
+	}
+	return names
+}
+
+// checkQuota compares the resources used by a task with the available
+// capacity of the namespace resource quotas. It returns the reason why the
+// task does not fit or an empty string, and an error when the task exceeds
+// the hard limit of a quota and can never fit.
+func (exec *mrExecutor) checkQuota(namespace string, usage api_v1.ResourceList) (string, error) {
+	quotaList, err := exec.k8sClient.Core().ResourceQuotas(namespace).List(api_v1.ListOptions{})
+	if err != nil {
+		log.Println(err)
+		return "", nil
+	}
+	for _, quota := range quotaList.Items {
+		for _, name := range sortedResourceNames(usage) {
+			hard, ok := quota.Status.Hard[name]
+			if !ok {
+				continue
+			}
+			requested := usage[name]
+			if requested.Cmp(hard) > 0 {
+				return "", fmt.Errorf("quota %s: %s %s requested, hard limit %s",
+					quota.Name, name, requested.String(), hard.String())
+			}
+			available := hard.Copy()
+			available.Sub(quota.Status.Used[name])
+			if requested.Cmp(*available) > 0 {
+				return fmt.Sprintf("quota %s: %s %s requested, %s available",
+					quota.Name, name, requested.String(), available.String()), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// checkBudget compares the resources requested by the running tasks of the
+// pipeline, plus the new request, with the pipeline budget.
+func (p *Pipeline) checkBudget(instance *Instance, requests api_v1.ResourceList) string {
+	budget := p.instanceSpec(instance).Budget
+	if len(budget) == 0 {
+		return ""
+	}
+	inUse := make(api_v1.ResourceList)
+	for _, other := range p.Instances {
+		if other == instance || other.State != StateRunning {
+			continue
+		}
+		for name, qty := range taskRequests(other.TaskList[other.Stage]) {
+			sum := inUse[name]
+			sum.Add(qty)
+			inUse[name] = sum
+		}
+	}
+	for _, name := range sortedResourceNames(requests) {
+		limit, ok := budget[name]
+		if !ok {
+			continue
+		}
+		requested, used := requests[name], inUse[name]
+		total := used.Copy()
+		total.Add(requested)
+		if total.Cmp(limit) > 0 {
+			return fmt.Sprintf("budget: %s %s requested, %s in use, limit %s",
+				name, requested.String(), used.String(), limit.String())
+		}
+	}
+	return ""
+}
+
+// checkBudgetLimit returns an error when the request exceeds the pipeline
+// budget on its own, so that the task can never be admitted.
+func (p *Pipeline) checkBudgetLimit(instance *Instance, requests api_v1.ResourceList) error {
+	budget := p.instanceSpec(instance).Budget
+	for _, name := range sortedResourceNames(requests) {
+		limit, ok := budget[name]
+		if !ok {
+			continue
+		}
+		if requested := requests[name]; requested.Cmp(limit) > 0 {
+			return fmt.Errorf("budget: %s %s requested, limit %s", name, requested.String(), limit.String())
+		}
+	}
+	return nil
+}
+
+// admitTask checks whether the namespace can fit the jobs of the current task
+// of an instance. Returns the reason why the task must wait or an empty
+// string. An error is returned when the task can never fit.
+func (exec *mrExecutor) admitTask(p *Pipeline, instance *Instance, stage int) (string, error) {
+	task := instance.TaskList[stage]
+	requests, limits := taskRequests(task), taskLimits(task)
+	if len(requests) == 0 && len(limits) == 0 {
+		return "", nil
+	}
+	if err := p.checkBudgetLimit(instance, requests); err != nil {
+		return "", err
+	}
+	if reason := p.checkBudget(instance, requests); reason != "" {
+		return reason, nil
+	}
+	return exec.checkQuota(p.instanceSpec(instance).Namespace, quotaUsage(requests, limits))
+}
+
+// retryPending creates the jobs of the tasks waiting for resources, when the
+// resources become available.
+func (exec *mrExecutor) retryPending(pipelines []*Pipeline) {
+	for _, p := range pipelines {
+		for _, instance := range p.Instances {
+			if instance.State != StatePending {
+				continue
+			}
+			reason, err := exec.admitTask(p, instance, instance.Stage)
+			if err != nil {
+				exec.post(&evTaskAbort{p, instance.ID, instance.Stage, err.Error(), time.Now()})
+				continue
+			}
+			if reason != "" {
+				instance.Reason = reason
+				continue
+			}
+			log.Printf("%s:%d resources available", p.Name, instance.ID)
+			instance.State = StateRunning
+			instance.Reason = ""
+			exec.createJobs(p, instance, instance.Stage)
+		}
+	}
+}
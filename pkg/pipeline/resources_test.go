@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/resource"
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+	batch_v1 "k8s.io/client-go/pkg/apis/batch/v1"
+)
+
+func makeResourceTask(memory string, parallelism int32) *Task {
+	job := &batch_v1.Job{}
+	job.Spec.Parallelism = &parallelism
+	job.Spec.Template.Spec.Containers = []api_v1.Container{
+		{
+			Name: "worker",
+			Resources: api_v1.ResourceRequirements{
+				Requests: api_v1.ResourceList{
+					api_v1.ResourceMemory: resource.MustParse(memory),
+				},
+			},
+		},
+	}
+	return &Task{jobs: []*batch_v1.Job{job}}
+}
+
+func TestTaskRequests(t *testing.T) {
+	requests := taskRequests(makeResourceTask("12Gi", 16))
+	memory := requests[api_v1.ResourceMemory]
+	if memory.Cmp(resource.MustParse("192Gi")) != 0 {
+		t.Error(memory.String())
+	}
+}
+
+func TestBudget(t *testing.T) {
+	p := &Pipeline{
+		Name: "cofilter",
+		Config: &Config{Spec: &Spec{
+			Name: "cofilter",
+			Budget: api_v1.ResourceList{
+				api_v1.ResourceMemory: resource.MustParse("256Gi"),
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateRunning, TaskList: []*Task{makeResourceTask("12Gi", 16)}},
+			{ID: 2, TaskList: []*Task{makeResourceTask("12Gi", 16)}},
+		},
+	}
+
+	requests := taskRequests(p.Instances[1].TaskList[0])
+	if reason := p.checkBudget(p.Instances[1], requests); reason == "" {
+		t.Error("expected budget to be exceeded")
+	}
+
+	p.Instances[0].State = StateComplete
+	if reason := p.checkBudget(p.Instances[1], requests); reason != "" {
+		t.Error(reason)
+	}
+}
+
+func TestQuotaUsage(t *testing.T) {
+	task := makeResourceTask("12Gi", 2)
+	task.jobs[0].Spec.Template.Spec.Containers[0].Resources.Limits = api_v1.ResourceList{
+		api_v1.ResourceMemory: resource.MustParse("16Gi"),
+	}
+	usage := quotaUsage(taskRequests(task), taskLimits(task))
+	expected := map[api_v1.ResourceName]string{
+		"memory":          "24Gi",
+		"requests.memory": "24Gi",
+		"limits.memory":   "32Gi",
+	}
+	if len(usage) != len(expected) {
+		t.Fatalf("unexpected usage %v", usage)
+	}
+	for name, value := range expected {
+		if qty := usage[name]; qty.Cmp(resource.MustParse(value)) != 0 {
+			t.Errorf("%s: %s", name, qty.String())
+		}
+	}
+}
+
+func TestBudgetLimit(t *testing.T) {
+	p := &Pipeline{
+		Name: "cofilter",
+		Config: &Config{Spec: &Spec{
+			Name: "cofilter",
+			Budget: api_v1.ResourceList{
+				api_v1.ResourceMemory: resource.MustParse("128Gi"),
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, TaskList: []*Task{makeResourceTask("12Gi", 16)}},
+			{ID: 2, TaskList: []*Task{makeResourceTask("12Gi", 4)}},
+		},
+	}
+	if err := p.checkBudgetLimit(p.Instances[0], taskRequests(p.Instances[0].TaskList[0])); err == nil {
+		t.Error("expected the task to exceed the budget")
+	}
+	if err := p.checkBudgetLimit(p.Instances[1], taskRequests(p.Instances[1].TaskList[0])); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionSpec defines when the finished instances of a pipeline are
+// deleted. Instances that are not among the KeepLast most recent finished
+// instances are deleted once the retention period of their final state has
+// elapsed. When the period of a state is not set, instances in that state
+// are only limited by KeepLast.
+type RetentionSpec struct {
+	// KeepLast is the number of most recent finished instances that are
+	// always kept.
+	KeepLast int `json:",omitempty"`
+	// Successful is the retention period of completed instances
+	// (e.g. "7d" or "12h").
+	Successful string `json:",omitempty"`
+	// Failed is the retention period of stopped instances.
+	Failed string `json:",omitempty"`
+	// DeleteWorkDir deletes the objects in the work directory of the
+	// instances that are collected.
+	DeleteWorkDir bool `json:",omitempty"`
+}
+
+// parseRetentionPeriod parses a duration that may be expressed in days
+// (e.g. "30d").
+func parseRetentionPeriod(v string) (time.Duration, error) {
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid retention period %s", v)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention period %s", v)
+	}
+	return d, nil
+}
+
+func validateRetention(spec *RetentionSpec) error {
+	if spec.KeepLast < 0 {
+		return &validationError{"retention keepLast must not be negative"}
+	}
+	for _, v := range []string{spec.Successful, spec.Failed} {
+		if v == "" {
+			continue
+		}
+		if _, err := parseRetentionPeriod(v); err != nil {
+			return &validationError{err.Error()}
+		}
+	}
+	return nil
+}
+
+// expiredInstances returns the instances of a pipeline that the retention
+// policy allows to delete.
+func (exec *mrExecutor) expiredInstances(p *Pipeline, now time.Time) []*Instance {
+	spec := p.Config.Spec.Retention
+	if spec == nil {
+		return nil
+	}
+	var finished []*Instance
+	for _, instance := range p.Instances {
+		if instance.State != StateComplete && instance.State != StateStopped {
+			continue
+		}
+		if instance.EndTime.IsZero() {
+			// instances restored from a checkpoint without an end time
+			// start their retention period now
+			instance.EndTime = now
+		}
+		finished = append(finished, instance)
+	}
+	sort.Sort(sort.Reverse(instancesByEndTime(finished)))
+
+	var expired []*Instance
+	for i, instance := range finished {
+		if i < spec.KeepLast || !exec.instanceCollectable(p, instance) {
+			continue
+		}
+		period := spec.Successful
+		if instance.State == StateStopped {
+			period = spec.Failed
+		}
+		if period == "" {
+			if spec.KeepLast > 0 {
+				expired = append(expired, instance)
+			}
+			continue
+		}
+		if d, err := parseRetentionPeriod(period); err == nil && now.Sub(instance.EndTime) >= d {
+			expired = append(expired, instance)
+		}
+	}
+	return expired
+}
+
+// instanceCollectable returns false for the instances that other objects
+// still depend on: instances of an unfinished backfill, sub-pipeline
+// instances of an active parent and the sources of a work dir copy.
+func (exec *mrExecutor) instanceCollectable(p *Pipeline, instance *Instance) bool {
+	if instance.Backfill != 0 {
+		if b := p.getBackfill(instance.Backfill); b != nil && (b.State == BackfillRunning || b.State == BackfillPaused) {
+			return false
+		}
+	}
+	for _, clone := range p.Instances {
+		if clone.isCloning() && clone.Clone.Source.Pipeline == p.Name && clone.Clone.Source.ID == instance.ID {
+			return false
+		}
+	}
+	if ref := instance.Parent; ref != nil {
+		exec.Lock()
+		parent := exec.pipelines[ref.Pipeline]
+		exec.Unlock()
+		if parent != nil {
+			if pi := parent.getInstance(ref.ID); pi != nil && pi.isActive() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// collectInstances deletes the instances that have exceeded the retention
+// policy of their pipeline.
+func (exec *mrExecutor) collectInstances(pipelines []*Pipeline) {
+	now := time.Now()
+	for _, p := range pipelines {
+		expired := exec.expiredInstances(p, now)
+		for _, instance := range expired {
+			log.Printf("%s: retention: deleting instance %d (%s)", p.Name, instance.ID, instance.State)
+			workDir := p.instanceWorkDir(instance)
+			p.deleteInstance(exec.k8sClient, instance)
+			if p.Config.Spec.Retention.DeleteWorkDir && workDir != "" {
+				go deleteWorkDir(workDir)
+			}
+		}
+	}
+}
+
+// deleteWorkDir deletes the objects under an instance work directory.
+func deleteWorkDir(workDir string) {
+	backend, err := storageFor(workDir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	objects, err := backend.List(ctx, strings.TrimSuffix(workDir, "/")+"/", 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, obj := range objects {
+		if err := backend.Delete(ctx, obj.URI); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+type instancesByEndTime []*Instance
+
+func (s instancesByEndTime) Len() int {
+	return len(s)
+}
+func (s instancesByEndTime) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s instancesByEndTime) Less(i, j int) bool {
+	if s[i].EndTime.Equal(s[j].EndTime) {
+		return s[i].ID < s[j].ID
+	}
+	return s[i].EndTime.Before(s[j].EndTime)
+}
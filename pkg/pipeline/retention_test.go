@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionPeriod(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"7d", 7 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"0d", 0, true},
+		{"-1d", 0, false},
+		{"d", 0, false},
+		{"week", 0, false},
+	}
+	for _, tc := range testCases {
+		d, err := parseRetentionPeriod(tc.value)
+		if tc.valid != (err == nil) || d != tc.expected {
+			t.Errorf("%s: %v %v", tc.value, d, err)
+		}
+	}
+}
+
+func TestExpiredInstances(t *testing.T) {
+	now := time.Now()
+	exec := &mrExecutor{
+		pipelines: make(map[string]*Pipeline),
+		events:    make(chan smEvent, 16),
+	}
+	p := &Pipeline{
+		Name: "test",
+		Config: &Config{Spec: &Spec{
+			Name: "test",
+			Retention: &RetentionSpec{
+				KeepLast:   1,
+				Successful: "7d",
+				Failed:     "1d",
+			},
+		}},
+		Instances: []*Instance{
+			{ID: 1, State: StateComplete, EndTime: now.Add(-10 * 24 * time.Hour)},
+			{ID: 2, State: StateComplete, EndTime: now.Add(-2 * 24 * time.Hour)},
+			{ID: 3, State: StateStopped, EndTime: now.Add(-2 * 24 * time.Hour)},
+			{ID: 4, State: StateStopped, EndTime: now.Add(-time.Hour)},
+			{ID: 5, State: StateRunning},
+			{ID: 6, State: StateComplete, EndTime: now.Add(-30 * 24 * time.Hour), Backfill: 1},
+			{ID: 7, State: StateComplete, EndTime: now.Add(-30 * 24 * time.Hour)},
+			{ID: 8, State: StateComplete},
+		},
+		Backfills: []*Backfill{
+			{ID: 1, State: BackfillRunning},
+		},
+	}
+	exec.pipelines[p.Name] = p
+
+	var ids []int
+	for _, instance := range exec.expiredInstances(p, now) {
+		ids = append(ids, instance.ID)
+	}
+	// 8 has no end time and is the most recent; 4 and 2 are within their
+	// retention period and 6 belongs to a running backfill.
+	if len(ids) != 3 || ids[0] != 3 || ids[1] != 1 || ids[2] != 7 {
+		t.Errorf("unexpected expired instances %v", ids)
+	}
+	if p.Instances[7].EndTime.IsZero() {
+		t.Error("end time not initialized")
+	}
+
+	p.Config.Spec.Retention = &RetentionSpec{KeepLast: 3}
+	ids = nil
+	for _, instance := range exec.expiredInstances(p, now) {
+		ids = append(ids, instance.ID)
+	}
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 1 || ids[2] != 7 {
+		t.Errorf("unexpected expired instances %v", ids)
+	}
+
+	// the source of a work dir copy is kept until the copy completes
+	clone := &Instance{ID: 9, Clone: &CloneStatus{
+		Source: InstanceRef{Pipeline: "test", ID: 7},
+		State:  CloneCopying,
+	}}
+	p.Instances = append(p.Instances, clone)
+	ids = nil
+	for _, instance := range exec.expiredInstances(p, now) {
+		ids = append(ids, instance.ID)
+	}
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 1 {
+		t.Errorf("unexpected expired instances %v", ids)
+	}
+	clone.Clone.State = CloneComplete
+	if expired := exec.expiredInstances(p, now); len(expired) != 3 {
+		t.Errorf("expected 3 expired instances, got %d", len(expired))
+	}
+}
+
+func TestInstanceIDNotReused(t *testing.T) {
+	p := &Pipeline{
+		Name:   "test",
+		Config: &Config{Spec: &Spec{Name: "test"}},
+		Instances: []*Instance{
+			{ID: 1},
+			{ID: 2},
+		},
+	}
+	p.Instances = p.Instances[:1]
+	p.LastID = 2
+	instance, err := p.createInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.ID != 3 {
+		t.Errorf("expected instance 3, got %d", instance.ID)
+	}
+}
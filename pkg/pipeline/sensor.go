@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultSensorInterval = time.Minute
+)
+
+// sensor polls a storage location on behalf of a pipeline task.
+type sensor struct {
+	uri      string
+	prefix   bool
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func makeSensor(spec *SensorSpec, uri string) *sensor {
+	s := &sensor{
+		uri:      uri,
+		prefix:   spec.Prefix && spec.Marker == "",
+		interval: defaultSensorInterval,
+	}
+	if spec.Marker != "" {
+		s.uri = pathJoin(uri, spec.Marker)
+	}
+	// durations are checked by validateSensor
+	if d, err := time.ParseDuration(spec.Interval); err == nil && d > 0 {
+		s.interval = d
+	}
+	if d, err := time.ParseDuration(spec.Timeout); err == nil {
+		s.timeout = d
+	}
+	return s
+}
+
+// run polls storage until the uri is present, the timeout expires or done is
+// closed. It returns true when the uri is present.
+func (s *sensor) run(done chan struct{}) (bool, error) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var deadline <-chan time.Time
+	if s.timeout > 0 {
+		deadline = time.After(s.timeout)
+	}
+	for {
+		exists, err := storageExists(s.uri, s.prefix)
+		if err != nil {
+			log.Println(err)
+		} else if exists {
+			return true, nil
+		}
+
+		select {
+		case <-done:
+			return false, nil
+		case <-deadline:
+			return false, fmt.Errorf("timeout waiting for %s", s.uri)
+		case <-ticker.C:
+		}
+	}
+}
+
+// startSensor executes a sensor task in a goroutine. The task completes when the
+// storage location is present and is aborted on timeout.
+func (exec *mrExecutor) startSensor(p *Pipeline, instance *Instance, stage int) {
+	taskSpec := &p.instanceSpec(instance).Tasks[stage]
+	vars := makeTemplateVars(p.instanceSpec(instance), instance, taskSpec, &taskSpec.JobTemplate)
+	uri := taskSpec.Sensor.URI
+	if expanded, err := expandTemplateArgs(vars, []string{uri}); err == nil {
+		uri = expanded[0]
+	} else {
+		log.Println(err)
+	}
+
+	task := instance.TaskList[stage]
+	done := make(chan struct{})
+	task.done = done
+
+	s := makeSensor(taskSpec.Sensor, uri)
+	go func() {
+		ok, err := s.run(done)
+		if err != nil {
+			exec.events <- &evTaskAbort{p, instance.ID, stage, err.Error(), time.Now()}
+		} else if ok {
+			exec.events <- &evTaskComplete{p, instance.ID, stage, time.Now()}
+		}
+	}()
+}
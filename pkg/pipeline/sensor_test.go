@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSensorMarker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSensorMarker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := makeSensor(&SensorSpec{Marker: "_SUCCESS", Interval: "10ms", Timeout: "5s"}, "file://"+dir)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(dir, "_SUCCESS"), nil, 0644)
+	}()
+
+	ok, err := s.run(make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("sensor did not detect marker")
+	}
+}
+
+func TestSensorPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSensorPrefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "part-00000"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := makeSensor(&SensorSpec{Prefix: true, Interval: "10ms"}, "file://"+dir+"/part-")
+	ok, err := s.run(make(chan struct{}))
+	if err != nil || !ok {
+		t.Error(ok, err)
+	}
+}
+
+func TestSensorTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSensorTimeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := makeSensor(&SensorSpec{Interval: "10ms", Timeout: "50ms"}, "file://"+dir+"/missing")
+	ok, err := s.run(make(chan struct{}))
+	if ok || err == nil {
+		t.Error("expected timeout")
+	}
+}
@@ -21,6 +21,18 @@ const (
 	eventTaskCreate
 	eventTaskAbort
 	eventTaskComplete
+	eventBackfillSchedule
+	eventTaskApproval
+	eventInstancePause
+	eventInstanceUpgrade
+	eventCloneComplete
+	eventTaskCacheKey
+	eventTaskOutputs
+	eventPodStatus
+	eventPodLog
+	eventConfigInstall
+	eventMapTaskInputs
+	eventBackfillCancel
 )
 
 type smEvent interface {
@@ -58,21 +70,45 @@ func (exec *mrExecutor) handlePipelineRun(event *evPipelineRun) {
 	p := event.pipeline
 	instance := p.getInstance(event.instanceID)
 
+	p.State = StateRunning
+	admitted, target := exec.admitInstance(p, instance, event.taskIndex)
+	if !admitted {
+		log.Printf("%s:%d queued", p.Name, instance.ID)
+		return
+	}
+	if target != nil {
+		log.Printf("%s:%d preempted by %s:%d", target.p.Name, target.instance.ID, p.Name, instance.ID)
+		target.p.pauseTask(exec.k8sClient, target.instance)
+	}
+	exec.runInstance(p, instance, event.taskIndex)
+}
+
+// runInstance starts the execution of an instance at the specified stage.
+func (exec *mrExecutor) runInstance(p *Pipeline, instance *Instance, stage int) {
+	// stop the controller tasks of a previous execution
+	for _, task := range instance.TaskList {
+		if task.done != nil {
+			close(task.done)
+			task.done = nil
+		}
+	}
 	// delete all jobs greater >= taskIndex
-	for i := event.taskIndex; i < len(instance.TaskList); i++ {
+	for i := stage; i < len(instance.TaskList); i++ {
 		p.deleteTaskResources(exec.k8sClient, instance, i)
 	}
 
 	p.State = StateRunning
-	instance.Stage = event.taskIndex
+	instance.Stage = stage
 	instance.State = StateRunning
+	instance.Reason = ""
+	instance.EndTime = time.Time{}
 
 	watch := MakeWatcher(p, instance)
 	instance.watcher = watch
 	go watch.Run(exec.k8sClient, exec.events)
 
 	// create task
-	exec.events <- &evTaskCreate{p, instance.ID, event.taskIndex}
+	exec.post(&evTaskCreate{p, instance.ID, stage})
 }
 
 type evPipelineStatus struct {
@@ -123,11 +159,11 @@ func (exec *mrExecutor) handlePipelineStatus(event *evPipelineStatus) {
 	}
 
 	if task.completed == len(task.jobs) {
-		exec.events <- &evTaskComplete{
+		exec.post(&evTaskComplete{
 			pipeline:   pipeline,
 			instanceID: instance.ID,
 			taskIndex:  instance.Stage,
-		}
+		})
 		return
 	}
 
@@ -146,7 +182,7 @@ func (exec *mrExecutor) handlePipelineStatus(event *evPipelineStatus) {
 
 	// if the number of failures has gone past threshold abort
 	if status.Failed > threshold {
-		exec.events <- &evTaskAbort{pipeline, instance.ID, instance.Stage, "Too many failures", time.Now()}
+		exec.post(&evTaskAbort{pipeline, instance.ID, instance.Stage, "Too many failures", time.Now()})
 	}
 }
 
@@ -175,26 +211,41 @@ func (ev *evInstanceDelete) String() string {
 func (exec *mrExecutor) handleInstanceDelete(event *evInstanceDelete) {
 	p := event.pipeline
 	instance := p.getInstance(event.instanceID)
-	if instance != nil {
-		p.deleteInstance(instance)
+	if instance == nil {
+		return
+	}
+	if instance.isCloning() {
+		log.Printf("%s:%d cannot delete an instance while it is being cloned", p.Name, instance.ID)
+		return
 	}
+	p.deleteInstance(exec.k8sClient, instance)
 }
 
-func (exec *mrExecutor) instanceStop(p *Pipeline, instance *Instance) {
-	instance.State = StateStopped
-	instance.watcher.Shutdown()
-	instance.watcher = nil
+func (exec *mrExecutor) instanceStop(p *Pipeline, instance *Instance, state ExecState) {
+	instance.State = state
+	instance.EndTime = time.Now()
+	if instance.Preempted {
+		exec.dequeueInstance(p, instance.ID)
+		instance.Preempted = false
+	}
+	if instance.watcher != nil {
+		instance.watcher.Shutdown()
+		instance.watcher = nil
+	}
 
 	var running int
 	for _, instanceIter := range p.Instances {
-		if instanceIter.State == StateRunning {
+		if instanceIter.isActive() {
 			running++
 		}
 	}
 	if running == 0 {
-		exec.events <- &evPipelineStop{p}
+		exec.post(&evPipelineStop{p})
 	}
-
+	if instance.Backfill != 0 {
+		exec.post(&evBackfillSchedule{p})
+	}
+	exec.dispatchQueue()
 }
 
 type evTaskCreate struct {
@@ -211,18 +262,72 @@ func (exec *mrExecutor) handleTaskCreate(event *evTaskCreate) {
 	pipeline := event.pipeline
 	instance := pipeline.getInstance(event.instanceID)
 
-	task := pipeline.Config.Spec.Tasks[event.taskIndex]
+	if pipeline.instanceSpec(instance).Tasks[event.taskIndex].Map != nil {
+		// the map inputs are listed in the background
+		exec.startMapTask(pipeline, instance, event.taskIndex)
+		return
+	}
+	if err := exec.renderTask(pipeline, instance, event.taskIndex, nil); err != nil {
+		exec.post(&evTaskAbort{pipeline, event.instanceID, event.taskIndex, err.Error(), time.Now()})
+		return
+	}
+	exec.startTask(pipeline, instance, event.taskIndex)
+}
+
+// startTask executes a rendered task.
+func (exec *mrExecutor) startTask(pipeline *Pipeline, instance *Instance, stage int) {
+	task := pipeline.instanceSpec(instance).Tasks[stage]
+	if task.Sensor != nil {
+		exec.startSensor(pipeline, instance, stage)
+		return
+	}
+	if task.Approval != nil {
+		exec.startApproval(pipeline, instance, stage)
+		return
+	}
+	if task.Pipeline != nil {
+		if err := exec.startSubPipeline(pipeline, instance, stage); err != nil {
+			exec.post(&evTaskAbort{pipeline, instance.ID, stage, err.Error(), time.Now()})
+		}
+		return
+	}
 	if task.EtcdLock != "" {
 		// if err := etcdDeleteLock(pipeline.Spec.Namespace, pipeline.Spec.Name+"-"+task.EtcdLock, event.instanceID); err != nil {
 		// 	glog.Error(err)
 		// }
 	}
 
-	if len(task.Services) > 0 {
-		pipeline.createServices(instance, event.taskIndex)
+	if task.Cache {
+		exec.startCacheLookup(pipeline, instance, stage)
+		return
 	}
+	exec.startJobs(pipeline, instance, stage)
+}
 
-	pipeline.createTask(exec.k8sClient, instance, event.taskIndex)
+// startJobs creates the jobs of a task once the resources they request are
+// available.
+func (exec *mrExecutor) startJobs(p *Pipeline, instance *Instance, stage int) {
+	reason, err := exec.admitTask(p, instance, stage)
+	if err != nil {
+		exec.post(&evTaskAbort{p, instance.ID, stage, err.Error(), time.Now()})
+		return
+	}
+	if reason != "" {
+		log.Printf("%s:%d task %s pending: %s", p.Name, instance.ID, p.instanceSpec(instance).Tasks[stage].Name, reason)
+		instance.State = StatePending
+		instance.Reason = reason
+		return
+	}
+	exec.createJobs(p, instance, stage)
+}
+
+// createJobs creates the services and jobs of a task.
+func (exec *mrExecutor) createJobs(p *Pipeline, instance *Instance, stage int) {
+	if len(p.instanceSpec(instance).Tasks[stage].Services) > 0 {
+		p.createServices(instance, stage)
+	}
+
+	p.createTask(exec.k8sClient, instance, stage)
 }
 
 type evTaskAbort struct {
@@ -247,8 +352,23 @@ func (exec *mrExecutor) handleTaskAbort(event *evTaskAbort) {
 		return
 	}
 
+	if !instance.isActive() {
+		return
+	}
+
+	if instance.State == StateQueued {
+		exec.dequeueInstance(p, instance.ID)
+		exec.instanceStop(p, instance, StateStopped)
+		exec.notifyParent(p, instance, event.msg)
+		return
+	}
+
 	p.cancelInstance(exec.k8sClient, instance)
-	exec.instanceStop(p, instance)
+	if p.instanceSpec(instance).Tasks[instance.Stage].Pipeline != nil {
+		exec.stopSubPipeline(p, instance, instance.Stage)
+	}
+	exec.instanceStop(p, instance, StateStopped)
+	exec.notifyParent(p, instance, event.msg)
 }
 
 type evTaskComplete struct {
@@ -271,23 +391,201 @@ func (exec *mrExecutor) handleTaskComplete(event *evTaskComplete) {
 		log.Println("Invalid instance id ", event.instanceID)
 		return
 	}
+	// completions that arrive after the instance was stopped or restarted
+	if !instance.isActive() || instance.Stage != event.taskIndex {
+		log.Printf("%s:%d ignoring completion of task %d", p.Name, instance.ID, event.taskIndex)
+		return
+	}
+
+	if instance.State == StatePaused {
+		// advance when the instance is resumed
+		instance.TaskList[event.taskIndex].completePending = true
+		return
+	}
 
-	if event.taskIndex < len(p.Config.Spec.Tasks)-1 {
+	task := instance.TaskList[event.taskIndex]
+	if len(p.instanceSpec(instance).Tasks[event.taskIndex].Outputs) > 0 && !task.outputsVerified {
+		exec.verifyOutputs(p, instance, event.taskIndex)
+		return
+	}
+	if task.cacheKey != "" {
+		task.CacheKey = task.cacheKey
+	}
+	exec.fireTriggers(p, instance, p.instanceSpec(instance).Tasks[event.taskIndex].Name)
+
+	if event.taskIndex < len(p.instanceSpec(instance).Tasks)-1 {
 		instance.Stage = event.taskIndex + 1
-		exec.events <- &evTaskCreate{p, event.instanceID, instance.Stage}
+		exec.post(&evTaskCreate{p, event.instanceID, instance.Stage})
 		return
 	}
 
 	// Instance Complete
-	exec.instanceStop(p, instance)
+	exec.instanceStop(p, instance, StateComplete)
+	exec.fireTriggers(p, instance, "")
+	exec.notifyParent(p, instance, "")
+}
+
+type evInstancePause struct {
+	pipeline   *Pipeline
+	instanceID int
+	resume     bool
+}
+
+func (ev *evInstancePause) eventType() smEventType { return eventInstancePause }
+func (ev *evInstancePause) String() string {
+	if ev.resume {
+		return fmt.Sprintf("RESUME %s:%d", ev.pipeline.Name, ev.instanceID)
+	}
+	return fmt.Sprintf("PAUSE %s:%d", ev.pipeline.Name, ev.instanceID)
+}
+func (exec *mrExecutor) handleInstancePause(event *evInstancePause) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil {
+		log.Printf("%s unknown instance: %d", p.Name, event.instanceID)
+		return
+	}
+
+	if !event.resume {
+		if instance.State != StateRunning {
+			return
+		}
+		p.pauseTask(exec.k8sClient, instance)
+		instance.State = StatePaused
+		exec.dispatchQueue()
+		return
+	}
+
+	if instance.State != StatePaused {
+		return
+	}
+
+	// a paused instance does not hold an execution slot: the resume waits
+	// in the queue when the concurrency limits are reached.
+	exec.Lock()
+	canRun := exec.canRun(p)
+	if !canRun && !instance.Preempted {
+		instance.Preempted = true
+		exec.enqueue(QueueEntry{
+			Pipeline:  p.Name,
+			ID:        instance.ID,
+			Stage:     instance.Stage,
+			Priority:  instance.Priority,
+			Preempted: true,
+			Time:      time.Now(),
+		})
+	}
+	exec.Unlock()
+	if !canRun {
+		log.Printf("%s:%d resume queued", p.Name, instance.ID)
+		return
+	}
+
+	if instance.Preempted {
+		exec.dequeueInstance(p, instance.ID)
+		instance.Preempted = false
+	}
+	instance.State = StateRunning
+	exec.resumeInstance(p, instance)
+}
+
+// resumeInstance restores the jobs of a paused instance and completes the
+// task if it finished, or replays the background step that finished, while
+// paused.
+func (exec *mrExecutor) resumeInstance(p *Pipeline, instance *Instance) {
+	p.resumeTask(exec.k8sClient, instance)
+
+	task := instance.TaskList[instance.Stage]
+	if task.completePending {
+		task.completePending = false
+		exec.post(&evTaskComplete{p, instance.ID, instance.Stage, time.Now()})
+	}
+	if event := task.deferred; event != nil {
+		task.deferred = nil
+		exec.post(event)
+	}
+}
+
+type evInstanceUpgrade struct {
+	pipeline   *Pipeline
+	instanceID int
+}
+
+func (ev *evInstanceUpgrade) eventType() smEventType { return eventInstanceUpgrade }
+func (ev *evInstanceUpgrade) String() string {
+	return fmt.Sprintf("UPGRADE %s:%d", ev.pipeline.Name, ev.instanceID)
+}
+func (exec *mrExecutor) handleInstanceUpgrade(event *evInstanceUpgrade) {
+	p := event.pipeline
+	instance := p.getInstance(event.instanceID)
+	if instance == nil || instance.isActive() {
+		return
+	}
+	exec.Lock()
+	err := p.upgradeInstance(instance)
+	exec.Unlock()
+	if err != nil {
+		log.Printf("%s:%d upgrade: %v", p.Name, instance.ID, err)
+	}
+}
+
+type evBackfillSchedule struct {
+	pipeline *Pipeline
+}
+
+func (ev *evBackfillSchedule) eventType() smEventType { return eventBackfillSchedule }
+func (ev *evBackfillSchedule) String() string {
+	return "BACKFILL " + ev.pipeline.Name
+}
+func (exec *mrExecutor) handleBackfillSchedule(event *evBackfillSchedule) {
+	exec.scheduleBackfills(event.pipeline)
 }
 
 func (exec *mrExecutor) periodicCheck() {
 	exec.Lock()
-	defer exec.Unlock()
+	var pipelines []*Pipeline
+	for _, p := range exec.pipelines {
+		pipelines = append(pipelines, p)
+	}
+	exec.Unlock()
+
+	for _, p := range pipelines {
+		exec.scheduleBackfills(p)
+	}
+	exec.retryPending(pipelines)
+	exec.dispatchQueue()
+	exec.collectInstances(pipelines)
+}
+
+// post queues an event from the executor goroutine. The executor is the only
+// reader of the events channel, so it must not block on a send: events that
+// don't fit in the channel are kept in the overflow list.
+func (exec *mrExecutor) post(ev smEvent) {
+	if len(exec.overflow) == 0 {
+		select {
+		case exec.events <- ev:
+			return
+		default:
+		}
+	}
+	exec.overflow = append(exec.overflow, ev)
+}
+
+// flushOverflow moves overflow events to the events channel while there is
+// space available.
+func (exec *mrExecutor) flushOverflow() {
+	for len(exec.overflow) > 0 {
+		select {
+		case exec.events <- exec.overflow[0]:
+			exec.overflow = exec.overflow[1:]
+		default:
+			return
+		}
+	}
 }
 
 func (exec *mrExecutor) runOnce(t *time.Ticker) {
+	exec.flushOverflow()
 	select {
 	case ev := <-exec.events:
 		// glog.V(1).Info(ev.String())
@@ -309,6 +607,30 @@ func (exec *mrExecutor) runOnce(t *time.Ticker) {
 			exec.handleTaskAbort(ev.(*evTaskAbort))
 		case eventTaskComplete:
 			exec.handleTaskComplete(ev.(*evTaskComplete))
+		case eventBackfillSchedule:
+			exec.handleBackfillSchedule(ev.(*evBackfillSchedule))
+		case eventTaskApproval:
+			exec.handleTaskApproval(ev.(*evTaskApproval))
+		case eventInstancePause:
+			exec.handleInstancePause(ev.(*evInstancePause))
+		case eventInstanceUpgrade:
+			exec.handleInstanceUpgrade(ev.(*evInstanceUpgrade))
+		case eventCloneComplete:
+			exec.handleCloneComplete(ev.(*evCloneComplete))
+		case eventTaskCacheKey:
+			exec.handleTaskCacheKey(ev.(*evTaskCacheKey))
+		case eventTaskOutputs:
+			exec.handleTaskOutputs(ev.(*evTaskOutputs))
+		case eventPodStatus:
+			exec.handlePodStatus(ev.(*evPodStatus))
+		case eventPodLog:
+			exec.handlePodLog(ev.(*evPodLog))
+		case eventConfigInstall:
+			exec.handleConfigInstall(ev.(*evConfigInstall))
+		case eventMapTaskInputs:
+			exec.handleMapTaskInputs(ev.(*evMapTaskInputs))
+		case eventBackfillCancel:
+			exec.handleBackfillCancel(ev.(*evBackfillCancel))
 
 		}
 
@@ -0,0 +1,288 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storageTimeout bounds the duration of a storage operation, including the
+// time a reader or writer is kept open.
+const storageTimeout = 10 * time.Minute
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	URI     string
+	Size    int64
+	ModTime time.Time
+	// Checksum is a digest of the object content computed by the storage
+	// service, when available.
+	Checksum string `json:",omitempty"`
+}
+
+// Storage is implemented by the storage backends. Backends are shared
+// across pipelines and must be safe for concurrent use. Operations on
+// objects that do not exist return errors for which os.IsNotExist is true.
+type Storage interface {
+	// Open returns a reader for the object. The context must remain valid
+	// until the reader is closed.
+	Open(ctx context.Context, uri string) (io.ReadCloser, error)
+	// Create returns a writer for the object. The object is complete when
+	// the writer is closed.
+	Create(ctx context.Context, uri string) (io.WriteCloser, error)
+	// List returns the objects whose URI starts with prefix. At most max
+	// objects are returned when max is positive.
+	List(ctx context.Context, prefix string, max int) ([]ObjectInfo, error)
+	// Copy copies an object within the backend.
+	Copy(ctx context.Context, src, dst string) error
+	// Delete removes an object.
+	Delete(ctx context.Context, uri string) error
+	// Stat returns the object attributes.
+	Stat(ctx context.Context, uri string) (*ObjectInfo, error)
+}
+
+var storageRegistry = struct {
+	sync.Mutex
+	backends map[string]Storage
+}{
+	backends: map[string]Storage{
+		"file":      &fileStorage{},
+		"git":       defaultGitStorage,
+		"git+file":  defaultGitStorage,
+		"git+http":  defaultGitStorage,
+		"git+https": defaultGitStorage,
+		"git+ssh":   defaultGitStorage,
+		"gs":        &gsStorage{},
+		"http":      defaultHTTPStorage,
+		"https":     defaultHTTPStorage,
+		"s3":        &s3Storage{},
+	},
+}
+
+// RegisterStorage makes a backend available for URIs with the specified
+// scheme (e.g. "gs"), replacing any previous backend for the scheme.
+func RegisterStorage(scheme string, backend Storage) {
+	storageRegistry.Lock()
+	defer storageRegistry.Unlock()
+	storageRegistry.backends[scheme] = backend
+}
+
+func uriScheme(uri string) string {
+	if i := strings.Index(uri, "://"); i > 0 {
+		return uri[:i]
+	}
+	return ""
+}
+
+// storageFor returns the backend for the scheme of uri.
+func storageFor(uri string) (Storage, error) {
+	storageRegistry.Lock()
+	defer storageRegistry.Unlock()
+	if backend, ok := storageRegistry.backends[uriScheme(uri)]; ok {
+		return backend, nil
+	}
+	return nil, fmt.Errorf("unsupported uri scheme: %s", uri)
+}
+
+// notExist returns an error for a missing object that satisfies os.IsNotExist.
+func notExist(op, uri string) error {
+	return &os.PathError{Op: op, Path: uri, Err: os.ErrNotExist}
+}
+
+var errReadOnly = errors.New("read-only storage")
+
+// writableChecker is implemented by backends that may not support writes.
+type writableChecker interface {
+	writable() bool
+}
+
+// isWritable returns true when the backend supports Create, Copy and Delete.
+func isWritable(backend Storage) bool {
+	if c, ok := backend.(writableChecker); ok {
+		return c.writable()
+	}
+	return true
+}
+
+// pageLister is implemented by backends that list objects one page at a time.
+type pageLister interface {
+	// ListPage returns at most max objects whose URI starts with prefix,
+	// beginning with the page identified by token, and the token of the
+	// following page. The returned token is empty after the last page.
+	ListPage(ctx context.Context, prefix, token string, max int) ([]ObjectInfo, string, error)
+}
+
+// listPage returns a page of the objects whose URI starts with prefix. The
+// objects of backends without native paging are listed in full and the page
+// token is the URI of the last object of the previous page.
+func listPage(ctx context.Context, backend Storage, prefix, token string, max int) ([]ObjectInfo, string, error) {
+	if lister, ok := backend.(pageLister); ok {
+		return lister.ListPage(ctx, prefix, token, max)
+	}
+	objects, err := backend.List(ctx, prefix, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Sort(objectsByURI(objects))
+	start := sort.Search(len(objects), func(i int) bool {
+		return objects[i].URI > token
+	})
+	objects = objects[start:]
+	if len(objects) <= max {
+		return objects, "", nil
+	}
+	return objects[:max], objects[max-1].URI, nil
+}
+
+type objectsByURI []ObjectInfo
+
+func (s objectsByURI) Len() int {
+	return len(s)
+}
+func (s objectsByURI) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s objectsByURI) Less(i, j int) bool {
+	return s[i].URI < s[j].URI
+}
+
+// readOnly returns the error of a write or list operation on a read-only
+// backend.
+func readOnly(op, uri string) error {
+	return &os.PathError{Op: op, Path: uri, Err: errReadOnly}
+}
+
+// cancelReader releases the context of a reader when it is closed.
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// cancelWriter releases the context of a writer when it is closed.
+type cancelWriter struct {
+	io.WriteCloser
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Close() error {
+	defer w.cancel()
+	return w.WriteCloser.Close()
+}
+
+// newFileReader allocates a file reader. It is the responsibility of the caller to
+// call Close().
+func newFileReader(uri string) (io.ReadCloser, error) {
+	backend, err := storageFor(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	rd, err := backend.Open(ctx, uri)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelReader{rd, cancel}, nil
+}
+
+// newFileWriter allocates a file writer. The data is stored when the writer
+// is closed.
+func newFileWriter(uri string) (io.WriteCloser, error) {
+	backend, err := storageFor(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	wr, err := backend.Create(ctx, uri)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelWriter{wr, cancel}, nil
+}
+
+// storageExists checks whether the object at uri exists. When prefix is set it
+// checks for any object whose name starts with uri.
+func storageExists(uri string, prefix bool) (bool, error) {
+	backend, err := storageFor(uri)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	if !prefix {
+		if _, err := backend.Stat(ctx, uri); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	objects, err := backend.List(ctx, uri, 1)
+	return len(objects) > 0, err
+}
+
+// listFiles returns the URIs of the objects whose name starts with uri.
+func listFiles(uri string) ([]string, error) {
+	backend, err := storageFor(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	objects, err := backend.List(ctx, uri, 0)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(objects))
+	for i, obj := range objects {
+		files[i] = obj.URI
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// copyObject copies an object, using the backend copy operation when source
+// and destination use the same scheme.
+func copyObject(ctx context.Context, src, dst string) error {
+	srcBackend, err := storageFor(src)
+	if err != nil {
+		return err
+	}
+	dstBackend, err := storageFor(dst)
+	if err != nil {
+		return err
+	}
+	if uriScheme(src) == uriScheme(dst) {
+		return srcBackend.Copy(ctx, src, dst)
+	}
+
+	rd, err := srcBackend.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	wr, err := dstBackend.Create(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wr, rd); err != nil {
+		wr.Close()
+		return err
+	}
+	return wr.Close()
+}
@@ -0,0 +1,214 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fileScheme = "file://"
+
+var errStopWalk = errors.New("stop walk")
+
+// fileStorage implements file:// URIs on the local file system, such as NFS
+// or hostPath volumes shared with the jobs. Listing does not follow symbolic
+// links.
+type fileStorage struct{}
+
+func filePath(uri string) string {
+	return uri[len(fileScheme):]
+}
+
+func (s *fileStorage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return os.Open(filePath(uri))
+}
+
+func (s *fileStorage) Create(ctx context.Context, uri string) (io.WriteCloser, error) {
+	path := filePath(uri)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (s *fileStorage) List(ctx context.Context, uri string, max int) ([]ObjectInfo, error) {
+	prefix := filepath.Clean(filePath(uri))
+	dir := prefix
+	if strings.HasSuffix(uri, "/") {
+		prefix += "/"
+	} else {
+		dir = filepath.Dir(prefix)
+	}
+
+	var objects []ObjectInfo
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if max > 0 && len(objects) >= max {
+			return errStopWalk
+		}
+		if info.Mode().IsRegular() && strings.HasPrefix(path, prefix) {
+			objects = append(objects, ObjectInfo{
+				URI:     fileScheme + path,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == errStopWalk {
+		err = nil
+	}
+	return objects, err
+}
+
+// ListPage lists the files in the order of filepath.Walk. The page token is
+// the path of the last file of the previous page; directories that precede
+// it are not visited.
+func (s *fileStorage) ListPage(ctx context.Context, uri, token string, max int) ([]ObjectInfo, string, error) {
+	prefix := filepath.Clean(filePath(uri))
+	dir := prefix
+	if strings.HasSuffix(uri, "/") {
+		prefix += "/"
+	} else {
+		dir = filepath.Dir(prefix)
+	}
+
+	var objects []ObjectInfo
+	var next string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if token != "" && !walkAfter(path, token) {
+			if info.IsDir() && !strings.HasPrefix(token, path+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !strings.HasPrefix(path, prefix) {
+			return nil
+		}
+		if len(objects) == max {
+			next = objects[max-1].URI[len(fileScheme):]
+			return errStopWalk
+		}
+		objects = append(objects, ObjectInfo{
+			URI:     fileScheme + path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err == errStopWalk {
+		err = nil
+	}
+	return objects, next, err
+}
+
+// walkAfter returns true when filepath.Walk visits path after token.
+func walkAfter(path, token string) bool {
+	a := strings.Split(path, "/")
+	b := strings.Split(token, "/")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return len(a) > len(b)
+}
+
+// Copy copies a regular file, preserving its permissions. Symbolic links
+// are not followed.
+func (s *fileStorage) Copy(ctx context.Context, src, dst string) error {
+	info, err := os.Lstat(filePath(src))
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", src)
+	}
+	rd, err := os.Open(filePath(src))
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	path := filePath(dst)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	wr, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wr, rd); err != nil {
+		wr.Close()
+		return err
+	}
+	if err := wr.Close(); err != nil {
+		return err
+	}
+	// the file mode is subject to the umask when the file is created
+	return os.Chmod(path, info.Mode().Perm())
+}
+
+// checkLocalTarget verifies that writing name under root does not follow a
+// symbolic link out of root.
+func checkLocalTarget(root, name string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(root, name)
+	if !strings.HasPrefix(target, filepath.Clean(root)+string(filepath.Separator)) {
+		return fmt.Errorf("%s: path escapes %s", name, root)
+	}
+
+	// resolve the longest existing prefix of the target path
+	dir := filepath.Dir(target)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if resolved != resolvedRoot && !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+				return fmt.Errorf("%s: path escapes %s through a symbolic link", name, root)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s: destination is a symbolic link", name)
+	}
+	return nil
+}
+
+func (s *fileStorage) Delete(ctx context.Context, uri string) error {
+	return os.Remove(filePath(uri))
+}
+
+func (s *fileStorage) Stat(ctx context.Context, uri string) (*ObjectInfo, error) {
+	info, err := os.Stat(filePath(uri))
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{URI: uri, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
@@ -0,0 +1,220 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitFetchInterval is the minimum interval between fetches of a repository.
+// Files at a branch or tag may be up to this old.
+const gitFetchInterval = 30 * time.Second
+
+var gitCommitRe = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// gitStorage is a read-only backend for files in git repositories. URIs have
+// the form git://host/repo@ref:path, where the scheme may also be
+// git+https, git+http, git+ssh or git+file to select the transport of the
+// repository URL. Repositories are mirrored in a local directory and
+// fetched again when a ref other than a commit id is read.
+type gitStorage struct {
+	mutex   sync.Mutex
+	dir     string
+	fetched map[string]time.Time
+}
+
+var defaultGitStorage = newGitStorage(filepath.Join(os.TempDir(), "pipeline-git"))
+
+func newGitStorage(dir string) *gitStorage {
+	return &gitStorage{
+		dir:     dir,
+		fetched: make(map[string]time.Time),
+	}
+}
+
+// splitGitURI returns the repository URL, ref and file path of a git URI. The
+// ref is separated from the repository by the last @ that precedes the first
+// colon of the URL path, since refs can contain slashes but not colons and the
+// file path may contain @.
+func splitGitURI(uri string) (string, string, string, error) {
+	scheme := uriScheme(uri)
+	if scheme != "git" && !strings.HasPrefix(scheme, "git+") {
+		return "", "", "", fmt.Errorf("invalid git uri: %s", uri)
+	}
+	rest := uri[len(scheme)+len("://"):]
+	// skip the user and port of the host
+	start := strings.Index(rest, "/")
+	if start < 0 {
+		return "", "", "", fmt.Errorf("invalid git uri: %s: missing @ref", uri)
+	}
+	end := len(rest)
+	if colon := strings.Index(rest[start:], ":"); colon >= 0 {
+		end = start + colon
+	}
+	at := strings.LastIndex(rest[start:end], "@")
+	if at <= 0 {
+		return "", "", "", fmt.Errorf("invalid git uri: %s: missing @ref", uri)
+	}
+	at += start
+	elements := strings.SplitN(rest[at+1:], ":", 2)
+	if len(elements) < 2 || elements[0] == "" || elements[1] == "" || strings.HasPrefix(elements[0], "-") {
+		return "", "", "", fmt.Errorf("invalid git uri: %s: expected @ref:path", uri)
+	}
+	repo := strings.TrimPrefix(scheme, "git+") + "://" + rest[:at]
+	return repo, elements[0], strings.TrimPrefix(elements[1], "/"), nil
+}
+
+// gitEnv returns the environment of the git commands for repo. The http
+// headers of the repository host are passed as configuration variables in
+// the environment, rather than in the command line where other users can
+// see them.
+func gitEnv(repo string) []string {
+	var env []string
+	for _, v := range os.Environ() {
+		if strings.HasPrefix(v, "GIT_CONFIG_COUNT=") || strings.HasPrefix(v, "GIT_CONFIG_KEY_") || strings.HasPrefix(v, "GIT_CONFIG_VALUE_") {
+			continue
+		}
+		env = append(env, v)
+	}
+	env = append(env, "GIT_TERMINAL_PROMPT=0")
+
+	var count int
+	if u, err := url.Parse(repo); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		for name, values := range hostHeaders(u.Host) {
+			for _, value := range values {
+				env = append(env,
+					fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", count),
+					fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s: %s", count, name, value))
+				count++
+			}
+		}
+	}
+	if count > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", count))
+	}
+	return env
+}
+
+// gitCommand runs git with the configured http headers of the repository
+// host.
+func gitCommand(ctx context.Context, repo string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = gitEnv(repo)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// mirror returns the local directory of an up to date mirror of repo.
+func (s *gitStorage) mirror(ctx context.Context, repo, ref string) (string, error) {
+	hash := sha256.Sum256([]byte(repo))
+	dir := filepath.Join(s.dir, hex.EncodeToString(hash[:8]))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return "", err
+		}
+		if _, err := gitCommand(ctx, repo, "clone", "--quiet", "--mirror", repo, dir); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		s.fetched[repo] = time.Now()
+		return dir, nil
+	}
+
+	if gitCommitRe.MatchString(ref) {
+		if _, err := gitCommand(ctx, repo, "--git-dir", dir, "cat-file", "-e", ref+"^{commit}"); err == nil {
+			return dir, nil
+		}
+	} else if time.Since(s.fetched[repo]) < gitFetchInterval {
+		return dir, nil
+	}
+	if _, err := gitCommand(ctx, repo, "--git-dir", dir, "fetch", "--quiet", "--prune", "origin"); err != nil {
+		return "", err
+	}
+	s.fetched[repo] = time.Now()
+	return dir, nil
+}
+
+// object returns the repository, mirror directory and object name of uri.
+func (s *gitStorage) object(ctx context.Context, uri string) (string, string, string, error) {
+	repo, ref, path, err := splitGitURI(uri)
+	if err != nil {
+		return "", "", "", err
+	}
+	dir, err := s.mirror(ctx, repo, ref)
+	if err != nil {
+		return "", "", "", err
+	}
+	object := ref + ":" + path
+	if _, err := gitCommand(ctx, repo, "--git-dir", dir, "cat-file", "-e", object); err != nil {
+		return "", "", "", notExist("open", uri)
+	}
+	return repo, dir, object, nil
+}
+
+func (s *gitStorage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	repo, dir, object, err := s.object(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	data, err := gitCommand(ctx, repo, "--git-dir", dir, "cat-file", "blob", object)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *gitStorage) writable() bool { return false }
+
+func (s *gitStorage) Create(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return nil, readOnly("create", uri)
+}
+
+func (s *gitStorage) List(ctx context.Context, prefix string, max int) ([]ObjectInfo, error) {
+	return nil, readOnly("list", prefix)
+}
+
+func (s *gitStorage) Copy(ctx context.Context, src, dst string) error {
+	return readOnly("copy", dst)
+}
+
+func (s *gitStorage) Delete(ctx context.Context, uri string) error {
+	return readOnly("delete", uri)
+}
+
+func (s *gitStorage) Stat(ctx context.Context, uri string) (*ObjectInfo, error) {
+	repo, dir, object, err := s.object(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	out, err := gitCommand(ctx, repo, "--git-dir", dir, "cat-file", "-s", object)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{URI: uri, Size: size}, nil
+}
@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitGitURI(t *testing.T) {
+	testCases := []struct {
+		uri  string
+		repo string
+		ref  string
+		path string
+	}{
+		{"git://git.example.com/pipelines.git@master:conf/test.yaml", "git://git.example.com/pipelines.git", "master", "conf/test.yaml"},
+		{"git+https://git.example.com/pipelines@v1.2:/test.yaml", "https://git.example.com/pipelines", "v1.2", "test.yaml"},
+		{"git+ssh://git@git.example.com/pipelines@feature/x:test.yaml", "ssh://git@git.example.com/pipelines", "feature/x", "test.yaml"},
+		{"git+ssh://git@git.example.com/pipelines@main:conf/user@host.yaml", "ssh://git@git.example.com/pipelines", "main", "conf/user@host.yaml"},
+		{"git+ssh://git@git.example.com:22/pipelines@main:test.yaml", "ssh://git@git.example.com:22/pipelines", "main", "test.yaml"},
+		{"git://git.example.com/pipelines:test@v1.yaml", "", "", ""},
+		{"git://git.example.com/pipelines:test.yaml", "", "", ""},
+		{"git://git.example.com/pipelines@master", "", "", ""},
+		{"git://git.example.com/pipelines@--upload-pack=x:test.yaml", "", "", ""},
+	}
+	for _, tc := range testCases {
+		repo, ref, path, err := splitGitURI(tc.uri)
+		if tc.repo == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if repo != tc.repo || ref != tc.ref || path != tc.path {
+			t.Errorf("%s: got %s %s %s", tc.uri, repo, ref, path)
+		}
+	}
+}
+
+func TestGitStorage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	os.MkdirAll(filepath.Join(repo, "conf"), 0755)
+	git("init", "--quiet")
+	git("checkout", "--quiet", "-b", "master")
+	ioutil.WriteFile(filepath.Join(repo, "conf/test.yaml"), []byte("name: v1"), 0644)
+	git("add", ".")
+	git("commit", "--quiet", "-m", "v1")
+	v1 := git("rev-parse", "HEAD")
+	ioutil.WriteFile(filepath.Join(repo, "conf/test.yaml"), []byte("name: v2"), 0644)
+	git("commit", "--quiet", "-a", "-m", "v2")
+
+	backend := newGitStorage(filepath.Join(dir, "cache"))
+	read := func(uri string) string {
+		rd, err := backend.Open(context.Background(), uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		data, _ := ioutil.ReadAll(rd)
+		return string(data)
+	}
+	prefix := "git+file://" + repo + "@"
+	if content := read(prefix + "master:conf/test.yaml"); content != "name: v2" {
+		t.Errorf("master: %q", content)
+	}
+	if content := read(prefix + v1 + ":conf/test.yaml"); content != "name: v1" {
+		t.Errorf("%s: %q", v1, content)
+	}
+	if info, err := backend.Stat(context.Background(), prefix+v1+":conf/test.yaml"); err != nil || info.Size != 8 {
+		t.Errorf("stat: %v %v", info, err)
+	}
+	if _, err := backend.Open(context.Background(), prefix+"master:conf/missing.yaml"); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+
+	// a commit that is not in the mirror is fetched
+	ioutil.WriteFile(filepath.Join(repo, "conf/test.yaml"), []byte("name: v3"), 0644)
+	git("commit", "--quiet", "-a", "-m", "v3")
+	v3 := git("rev-parse", "HEAD")
+	if content := read(prefix + v3 + ":conf/test.yaml"); content != "name: v3" {
+		t.Errorf("%s: %q", v3, content)
+	}
+}
+
+func TestGitEnv(t *testing.T) {
+	SetHTTPHeader("git-env.example.com", "Authorization", "Bearer secret")
+	env := strings.Join(gitEnv("https://git-env.example.com/repo.git"), "\n")
+	for _, v := range []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Bearer secret",
+	} {
+		if !strings.Contains(env, v) {
+			t.Errorf("missing %s", v)
+		}
+	}
+	if env := strings.Join(gitEnv("https://other.example.com/repo.git"), "\n"); strings.Contains(env, "secret") {
+		t.Error("header sent to another host")
+	}
+}
@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+const googleStorageScheme = "gs://"
+
+// gsStorage implements gs:// URIs. The storage client is created on first
+// use and shared by all operations.
+type gsStorage struct {
+	mutex  sync.Mutex
+	client *storage.Client
+}
+
+func (s *gsStorage) getClient() (*storage.Client, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client == nil {
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+func splitGSURI(uri string) (string, string, error) {
+	if !strings.HasPrefix(uri, googleStorageScheme) {
+		return "", "", fmt.Errorf("invalid google storage uri: %s", uri)
+	}
+	elements := strings.SplitN(uri[len(googleStorageScheme):], "/", 2)
+	if len(elements) < 2 {
+		return "", "", fmt.Errorf("invalid google storage uri: %s", uri)
+	}
+	return elements[0], elements[1], nil
+}
+
+func (s *gsStorage) object(uri string) (*storage.ObjectHandle, error) {
+	bucket, name, err := splitGSURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Bucket(bucket).Object(name), nil
+}
+
+func (s *gsStorage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	obj, err := s.object(uri)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := obj.NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, notExist("open", uri)
+	}
+	return rd, err
+}
+
+func (s *gsStorage) Create(ctx context.Context, uri string) (io.WriteCloser, error) {
+	obj, err := s.object(uri)
+	if err != nil {
+		return nil, err
+	}
+	return obj.NewWriter(ctx), nil
+}
+
+func (s *gsStorage) List(ctx context.Context, uri string, max int) ([]ObjectInfo, error) {
+	bucket, prefix, err := splitGSURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	iter := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for max <= 0 || len(objects) < max {
+		attrs, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, ObjectInfo{
+			URI:      googleStorageScheme + bucket + "/" + attrs.Name,
+			Size:     attrs.Size,
+			ModTime:  attrs.Updated,
+			Checksum: gsChecksum(attrs),
+		})
+	}
+	return objects, nil
+}
+
+// ListPage uses the page tokens of the storage service.
+func (s *gsStorage) ListPage(ctx context.Context, uri, token string, max int) ([]ObjectInfo, string, error) {
+	bucket, prefix, err := splitGSURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := s.getClient()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var attrs []*storage.ObjectAttrs
+	iter := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	next, err := iterator.NewPager(iter, max, token).NextPage(&attrs)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]ObjectInfo, len(attrs))
+	for i, obj := range attrs {
+		objects[i] = ObjectInfo{
+			URI:      googleStorageScheme + bucket + "/" + obj.Name,
+			Size:     obj.Size,
+			ModTime:  obj.Updated,
+			Checksum: gsChecksum(obj),
+		}
+	}
+	return objects, next, nil
+}
+
+func (s *gsStorage) Copy(ctx context.Context, src, dst string) error {
+	srcObj, err := s.object(src)
+	if err != nil {
+		return err
+	}
+	dstObj, err := s.object(dst)
+	if err != nil {
+		return err
+	}
+	_, err = dstObj.CopierFrom(srcObj).Run(ctx)
+	if err == storage.ErrObjectNotExist {
+		return notExist("copy", src)
+	}
+	return err
+}
+
+func (s *gsStorage) Delete(ctx context.Context, uri string) error {
+	obj, err := s.object(uri)
+	if err != nil {
+		return err
+	}
+	err = obj.Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return notExist("delete", uri)
+	}
+	return err
+}
+
+func (s *gsStorage) Stat(ctx context.Context, uri string) (*ObjectInfo, error) {
+	obj, err := s.object(uri)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, notExist("stat", uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{URI: uri, Size: attrs.Size, ModTime: attrs.Updated, Checksum: gsChecksum(attrs)}, nil
+}
+
+// gsChecksum returns the MD5 hash of an object, or its CRC32C for composite
+// objects that have no MD5 hash.
+func gsChecksum(attrs *storage.ObjectAttrs) string {
+	if len(attrs.MD5) > 0 {
+		return hex.EncodeToString(attrs.MD5)
+	}
+	return fmt.Sprintf("crc32c:%08x", attrs.CRC32C)
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// httpHeaders contains the headers, such as Authorization, added to the
+// requests sent to a host. Headers are only sent to the host they are
+// configured for.
+var httpHeaders = struct {
+	sync.Mutex
+	hosts map[string]http.Header
+}{
+	hosts: make(map[string]http.Header),
+}
+
+// SetHTTPHeader adds a header to the http(s) and git requests sent to host.
+func SetHTTPHeader(host, name, value string) {
+	httpHeaders.Lock()
+	defer httpHeaders.Unlock()
+	header, ok := httpHeaders.hosts[host]
+	if !ok {
+		header = make(http.Header)
+		httpHeaders.hosts[host] = header
+	}
+	header.Add(name, value)
+}
+
+// hostHeaders returns a copy of the headers configured for a host.
+func hostHeaders(host string) http.Header {
+	httpHeaders.Lock()
+	defer httpHeaders.Unlock()
+	header := make(http.Header)
+	for k, v := range httpHeaders.hosts[host] {
+		header[k] = append([]string(nil), v...)
+	}
+	return header
+}
+
+// httpCacheEntry is a previously fetched object along with the validators
+// used to revalidate it.
+type httpCacheEntry struct {
+	etag         string
+	lastModified string
+	data         []byte
+}
+
+// httpStorage is a read-only backend for http:// and https:// URIs. Objects
+// that the server returns with an ETag or Last-Modified header are cached and
+// revalidated with a conditional request.
+type httpStorage struct {
+	mutex  sync.Mutex
+	client *http.Client
+	cache  map[string]*httpCacheEntry
+}
+
+var defaultHTTPStorage = newHTTPStorage(&http.Client{Timeout: time.Minute})
+
+func newHTTPStorage(client *http.Client) *httpStorage {
+	return &httpStorage{
+		client: client,
+		cache:  make(map[string]*httpCacheEntry),
+	}
+}
+
+func (s *httpStorage) fetch(ctx context.Context, uri string) (*httpCacheEntry, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header = hostHeaders(u.Host)
+
+	s.mutex.Lock()
+	cached := s.cache[uri]
+	s.mutex.Unlock()
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		s.mutex.Lock()
+		delete(s.cache, uri)
+		s.mutex.Unlock()
+		return nil, notExist("open", uri)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry := &httpCacheEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		data:         data,
+	}
+	s.mutex.Lock()
+	if entry.etag != "" || entry.lastModified != "" {
+		s.cache[uri] = entry
+	} else {
+		delete(s.cache, uri)
+	}
+	s.mutex.Unlock()
+	return entry, nil
+}
+
+func (s *httpStorage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	entry, err := s.fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(entry.data)), nil
+}
+
+func (s *httpStorage) writable() bool { return false }
+
+func (s *httpStorage) Create(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return nil, readOnly("create", uri)
+}
+
+func (s *httpStorage) List(ctx context.Context, prefix string, max int) ([]ObjectInfo, error) {
+	return nil, readOnly("list", prefix)
+}
+
+func (s *httpStorage) Copy(ctx context.Context, src, dst string) error {
+	return readOnly("copy", dst)
+}
+
+func (s *httpStorage) Delete(ctx context.Context, uri string) error {
+	return readOnly("delete", uri)
+}
+
+func (s *httpStorage) Stat(ctx context.Context, uri string) (*ObjectInfo, error) {
+	entry, err := s.fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	info := &ObjectInfo{URI: uri, Size: int64(len(entry.data))}
+	if t, err := http.ParseTime(entry.lastModified); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestHTTPStorage(t *testing.T) {
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/pipelines/test.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("name: test"))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	backend := newHTTPStorage(http.DefaultClient)
+	if _, err := backend.Open(context.Background(), srv.URL+"/pipelines/test.yaml"); err == nil {
+		t.Error("expected an error without the authorization header")
+	}
+
+	SetHTTPHeader(u.Host, "Authorization", "Bearer secret")
+	defer func() {
+		httpHeaders.Lock()
+		delete(httpHeaders.hosts, u.Host)
+		httpHeaders.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		rd, err := backend.Open(context.Background(), srv.URL+"/pipelines/test.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(rd)
+		rd.Close()
+		if string(data) != "name: test" {
+			t.Errorf("unexpected content %q", data)
+		}
+	}
+	if requests != 3 || notModified != 1 {
+		t.Errorf("expected a cached response: %d requests, %d not modified", requests, notModified)
+	}
+
+	if _, err := backend.Stat(context.Background(), srv.URL+"/pipelines/missing.yaml"); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+	if _, err := backend.Create(context.Background(), srv.URL+"/pipelines/test.yaml"); err == nil {
+		t.Error("expected create to fail")
+	}
+}
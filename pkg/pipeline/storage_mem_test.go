@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// memStorage keeps objects in memory. Tests register it for mem:// URIs.
+type memStorage struct {
+	sync.Mutex
+	objects map[string]*memObject
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: make(map[string]*memObject)}
+}
+
+func (s *memStorage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[uri]
+	if !ok {
+		return nil, notExist("open", uri)
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+type memWriter struct {
+	bytes.Buffer
+	storage *memStorage
+	uri     string
+}
+
+func (w *memWriter) Close() error {
+	w.storage.Lock()
+	defer w.storage.Unlock()
+	w.storage.objects[w.uri] = &memObject{data: w.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (s *memStorage) Create(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return &memWriter{storage: s, uri: uri}, nil
+}
+
+func (s *memStorage) List(ctx context.Context, prefix string, max int) ([]ObjectInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if max > 0 && len(keys) > max {
+		keys = keys[:max]
+	}
+	objects := make([]ObjectInfo, len(keys))
+	for i, k := range keys {
+		obj := s.objects[k]
+		objects[i] = ObjectInfo{URI: k, Size: int64(len(obj.data)), ModTime: obj.modTime}
+	}
+	return objects, nil
+}
+
+func (s *memStorage) Copy(ctx context.Context, src, dst string) error {
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[src]
+	if !ok {
+		return notExist("copy", src)
+	}
+	s.objects[dst] = &memObject{data: obj.data, modTime: time.Now()}
+	return nil
+}
+
+func (s *memStorage) Delete(ctx context.Context, uri string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.objects[uri]; !ok {
+		return notExist("delete", uri)
+	}
+	delete(s.objects, uri)
+	return nil
+}
+
+func (s *memStorage) Stat(ctx context.Context, uri string) (*ObjectInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[uri]
+	if !ok {
+		return nil, notExist("stat", uri)
+	}
+	return &ObjectInfo{URI: uri, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
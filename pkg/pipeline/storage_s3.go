@@ -0,0 +1,626 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	s3Scheme = "s3://"
+
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// s3PartSize is the size of the parts of a multipart upload. With the
+	// limit of 10000 parts per upload, objects of up to 160 GiB can be stored.
+	s3PartSize = 16 << 20
+)
+
+// s3Client implements the subset of the S3 REST API used by the pipeline
+// controller. It is compatible with S3-like services such as MinIO.
+type s3Client struct {
+	// endpoint overrides the AWS endpoint (e.g. http://minio:9000). Requests
+	// to an endpoint override use path style addressing.
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	httpClient   *http.Client
+	now          func() time.Time
+	// partSize overrides the size of the parts of multipart uploads.
+	partSize int
+}
+
+// newS3ClientFromEnv configures a client from the standard AWS environment
+// variables. S3_ENDPOINT selects an S3 compatible service.
+func newS3ClientFromEnv() *s3Client {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Client{
+		endpoint:     strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		httpClient:   &http.Client{Timeout: 5 * time.Minute},
+		now:          time.Now,
+	}
+}
+
+func splitS3URI(uri string) (string, string, error) {
+	elements := strings.SplitN(uri[len(s3Scheme):], "/", 2)
+	if len(elements) < 2 || elements[0] == "" {
+		return "", "", fmt.Errorf("invalid s3 uri: %s", uri)
+	}
+	return elements[0], elements[1], nil
+}
+
+// s3EscapePath encodes each segment of an object key.
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape implements the URI encoding required by the signature algorithm.
+func s3Escape(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func (c *s3Client) objectURL(bucket, key string, query url.Values) *url.URL {
+	u := &url.URL{}
+	if c.endpoint != "" {
+		base, err := url.Parse(c.endpoint)
+		if err == nil {
+			u.Scheme = base.Scheme
+			u.Host = base.Host
+		}
+		u.Path = "/" + bucket
+		u.RawPath = "/" + bucket
+		if key != "" {
+			u.Path += "/" + key
+			u.RawPath += "/" + s3EscapePath(key)
+		}
+	} else {
+		u.Scheme = "https"
+		u.Host = bucket + ".s3." + c.region + ".amazonaws.com"
+		u.Path = "/" + key
+		u.RawPath = "/" + s3EscapePath(key)
+	}
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	return u
+}
+
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func s3SigningKey(secret, date, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secret), date)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, service)
+	return hmacSHA256(kService, "aws4_request")
+}
+
+// sign adds an AWS signature version 4 Authorization header to the request.
+// The host, content-type, range and x-amz-* headers are signed.
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	t := c.now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(s3SigningKey(c.secretKey, date, c.region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// do signs and executes a request. Error responses are converted into errors;
+// a missing object is reported as os.ErrNotExist.
+func (c *s3Client) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	c.sign(req, payloadHash)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notExist(method, u.String())
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+// s3Error is the error document returned by the service. CopyObject and
+// CompleteMultipartUpload may return it with a 200 status when the request
+// fails after the response has started.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+// decodeResult reads the XML body of a response into result, which may be
+// nil, and returns the error reported in the body, if any.
+func decodeResult(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var e s3Error
+	if xml.Unmarshal(data, &e) == nil {
+		return fmt.Errorf("s3 %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, e.Code, e.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+func (c *s3Client) getObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(bucket, key, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *s3Client) putObject(ctx context.Context, bucket, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, c.objectURL(bucket, key, nil), nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) copyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", "/"+srcBucket+"/"+s3EscapePath(srcKey))
+	resp, err := c.do(ctx, http.MethodPut, c.objectURL(dstBucket, dstKey, nil), header, nil)
+	if err != nil {
+		return err
+	}
+	return decodeResult(resp, nil)
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (c *s3Client) createMultipartUpload(ctx context.Context, bucket, key string) (string, error) {
+	query := url.Values{}
+	query.Set("uploads", "")
+	resp, err := c.do(ctx, http.MethodPost, c.objectURL(bucket, key, query), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadId string
+	}
+	if err := decodeResult(resp, &result); err != nil {
+		return "", err
+	}
+	if result.UploadId == "" {
+		return "", fmt.Errorf("s3 %s/%s: missing upload id", bucket, key)
+	}
+	return result.UploadId, nil
+}
+
+func (c *s3Client) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{}
+	query.Set("partNumber", fmt.Sprint(number))
+	query.Set("uploadId", uploadID)
+	resp, err := c.do(ctx, http.MethodPut, c.objectURL(bucket, key, query), nil, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *s3Client) completeMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []s3Part) error {
+	request := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Part    []s3Part
+	}{Part: parts}
+	body, err := xml.Marshal(&request)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	resp, err := c.do(ctx, http.MethodPost, c.objectURL(bucket, key, query), nil, body)
+	if err != nil {
+		return err
+	}
+	return decodeResult(resp, nil)
+}
+
+func (c *s3Client) abortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(bucket, key, query), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) deleteObject(ctx context.Context, bucket, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(bucket, key, nil), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) headObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	resp, err := c.do(ctx, http.MethodHead, c.objectURL(bucket, key, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	info := &ObjectInfo{Size: resp.ContentLength, Checksum: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+		ETag         string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// listObjects returns the objects whose key starts with prefix. The URI of
+// the objects is set to the key. When max is positive, at most max objects
+// are returned.
+func (c *s3Client) listObjects(ctx context.Context, bucket, prefix string, max int) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	var token string
+	for {
+		page, next, err := c.listObjectsPage(ctx, bucket, prefix, token, max-len(objects))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page...)
+		if next == "" || (max > 0 && len(objects) >= max) {
+			break
+		}
+		token = next
+	}
+	return objects, nil
+}
+
+// listObjectsPage returns a single page of a listing, starting at the page
+// identified by the continuation token, and the token of the next page.
+func (c *s3Client) listObjectsPage(ctx context.Context, bucket, prefix, token string, max int) ([]ObjectInfo, string, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if max > 0 {
+		query.Set("max-keys", fmt.Sprint(max))
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(bucket, "", query), nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result s3ListResult
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]ObjectInfo, len(result.Contents))
+	for i, obj := range result.Contents {
+		objects[i] = ObjectInfo{
+			URI:      obj.Key,
+			Size:     obj.Size,
+			ModTime:  obj.LastModified,
+			Checksum: strings.Trim(obj.ETag, `"`),
+		}
+	}
+	if !result.IsTruncated {
+		return objects, "", nil
+	}
+	return objects, result.NextContinuationToken, nil
+}
+
+// s3Writer buffers the object contents. Objects smaller than a part are
+// uploaded on Close with a single request; larger objects use a multipart
+// upload, with a part sent whenever a full part is buffered.
+type s3Writer struct {
+	client   *s3Client
+	ctx      context.Context
+	bucket   string
+	key      string
+	partSize int
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3Part
+	err      error
+}
+
+func (w *s3Writer) Write(data []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(data)
+	for w.buf.Len() >= w.partSize {
+		if w.err = w.uploadPart(w.buf.Next(w.partSize)); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	if w.uploadID == "" {
+		id, err := w.client.createMultipartUpload(w.ctx, w.bucket, w.key)
+		if err != nil {
+			return err
+		}
+		w.uploadID = id
+	}
+	number := len(w.parts) + 1
+	etag, err := w.client.uploadPart(w.ctx, w.bucket, w.key, w.uploadID, number, data)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, s3Part{PartNumber: number, ETag: etag})
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.uploadID == "" && w.err == nil {
+		return w.client.putObject(w.ctx, w.bucket, w.key, w.buf.Bytes())
+	}
+	err := w.err
+	if err == nil && w.buf.Len() > 0 {
+		err = w.uploadPart(w.buf.Bytes())
+	}
+	if err == nil {
+		err = w.client.completeMultipartUpload(w.ctx, w.bucket, w.key, w.uploadID, w.parts)
+	}
+	if err != nil && w.uploadID != "" {
+		// the parts of an incomplete upload are stored until it is aborted
+		w.client.abortMultipartUpload(w.ctx, w.bucket, w.key, w.uploadID)
+	}
+	return err
+}
+
+// s3Storage implements s3:// URIs. Unless a client is provided, the client is
+// configured from the environment on first use.
+type s3Storage struct {
+	mutex  sync.Mutex
+	client *s3Client
+}
+
+func newS3Storage(client *s3Client) *s3Storage {
+	return &s3Storage{client: client}
+}
+
+func (s *s3Storage) getClient() *s3Client {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client == nil {
+		s.client = newS3ClientFromEnv()
+	}
+	return s.client
+}
+
+func (s *s3Storage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	bucket, key, err := splitS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return s.getClient().getObject(ctx, bucket, key)
+}
+
+func (s *s3Storage) Create(ctx context.Context, uri string) (io.WriteCloser, error) {
+	bucket, key, err := splitS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	client := s.getClient()
+	partSize := client.partSize
+	if partSize == 0 {
+		partSize = s3PartSize
+	}
+	return &s3Writer{client: client, ctx: ctx, bucket: bucket, key: key, partSize: partSize}, nil
+}
+
+func (s *s3Storage) List(ctx context.Context, uri string, max int) ([]ObjectInfo, error) {
+	bucket, prefix, err := splitS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := s.getClient().listObjects(ctx, bucket, prefix, max)
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		objects[i].URI = s3Scheme + bucket + "/" + objects[i].URI
+	}
+	return objects, nil
+}
+
+// ListPage uses the continuation tokens of the service.
+func (s *s3Storage) ListPage(ctx context.Context, uri, token string, max int) ([]ObjectInfo, string, error) {
+	bucket, prefix, err := splitS3URI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	objects, next, err := s.getClient().listObjectsPage(ctx, bucket, prefix, token, max)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range objects {
+		objects[i].URI = s3Scheme + bucket + "/" + objects[i].URI
+	}
+	return objects, next, nil
+}
+
+func (s *s3Storage) Copy(ctx context.Context, src, dst string) error {
+	srcBucket, srcKey, err := splitS3URI(src)
+	if err != nil {
+		return err
+	}
+	dstBucket, dstKey, err := splitS3URI(dst)
+	if err != nil {
+		return err
+	}
+	return s.getClient().copyObject(ctx, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+func (s *s3Storage) Delete(ctx context.Context, uri string) error {
+	bucket, key, err := splitS3URI(uri)
+	if err != nil {
+		return err
+	}
+	return s.getClient().deleteObject(ctx, bucket, key)
+}
+
+func (s *s3Storage) Stat(ctx context.Context, uri string) (*ObjectInfo, error) {
+	bucket, key, err := splitS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.getClient().headObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	info.URI = uri
+	return info, nil
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func writeObject(t *testing.T, uri, data string) {
+	wr, err := newFileWriter(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wr.Write([]byte(data))
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemStorage(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+
+	writeObject(t, "mem://work/1/data/part-0", "0")
+	writeObject(t, "mem://work/1/data/part-1", "1")
+	writeObject(t, "mem://work/10/data/part-0", "0")
+
+	if ok, err := storageExists("mem://work/1/data/part-1", false); !ok || err != nil {
+		t.Errorf("object: %t %v", ok, err)
+	}
+	if ok, err := storageExists("mem://work/1/data/part-2", false); ok || err != nil {
+		t.Errorf("missing object: %t %v", ok, err)
+	}
+	if ok, err := storageExists("mem://work/2/", true); ok || err != nil {
+		t.Errorf("missing prefix: %t %v", ok, err)
+	}
+
+	if err := copyWorkDir("mem://work/1", "mem://work/2", nil, regexp.MustCompile("part-1$")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := listFiles("mem://work/2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, ",") != "mem://work/2/data/part-0" {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	backend, _ := storageFor("mem://")
+	if err := backend.Delete(nil, "mem://work/2/data/part-0"); err != nil {
+		t.Error(err)
+	}
+	if _, err := backend.Stat(context.Background(), "mem://work/2/data/part-0"); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
+
+func TestCopyAcrossSchemes(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeObject(t, "mem://work/1/conf/config.yaml", "name: test")
+	if err := copyWorkDir("mem://work/1", fileScheme+dir, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "conf/config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "name: test" {
+		t.Error(string(data))
+	}
+
+	if _, err := storageFor("ftp://host/file"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// startSubPipeline creates and runs the child instance of a sub-pipeline task.
+// The task completes or aborts when the child instance stops.
+func (exec *mrExecutor) startSubPipeline(p *Pipeline, instance *Instance, stage int) error {
+	taskSpec := &p.instanceSpec(instance).Tasks[stage]
+	sub := taskSpec.Pipeline
+
+	child := exec.PipelineLookup(sub.Name)
+	if child == nil && sub.URI != "" {
+		if err := exec.PipelineAdd(sub.Name, sub.URI); err != nil {
+			return err
+		}
+		child = exec.PipelineLookup(sub.Name)
+	}
+	if child == nil {
+		return fmt.Errorf("unknown pipeline %s", sub.Name)
+	}
+	if exec.isAncestor(p, instance, child.Name) {
+		return fmt.Errorf("pipeline %s cannot execute %s: recursive sub-pipeline", p.Name, child.Name)
+	}
+
+	var params map[string]string
+	if len(sub.Params) > 0 {
+		vars := makeTemplateVars(p.instanceSpec(instance), instance, taskSpec, &taskSpec.JobTemplate)
+		params = make(map[string]string)
+		for k, v := range sub.Params {
+			expanded, err := expandTemplateArgs(vars, []string{v})
+			if err != nil {
+				return err
+			}
+			params[k] = expanded[0]
+		}
+	}
+
+	exec.Lock()
+	defer exec.Unlock()
+	childInstance, err := child.createInstance(&instanceOptions{
+		params:   params,
+		parent:   &InstanceRef{Pipeline: p.Name, ID: instance.ID, Stage: stage},
+		priority: &instance.Priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	if instance.Children == nil {
+		instance.Children = make(map[string]*InstanceRef)
+	}
+	instance.Children[taskSpec.Name] = &InstanceRef{
+		Pipeline: child.Name,
+		ID:       childInstance.ID,
+		Stage:    stage,
+		State:    StateRunning,
+		WorkDir:  child.instanceWorkDir(childInstance),
+	}
+	exec.post(&evPipelineRun{child, childInstance.ID, 0})
+	return nil
+}
+
+// isAncestor returns true when name is the pipeline of instance or of one of
+// the parent instances that it executes under.
+func (exec *mrExecutor) isAncestor(p *Pipeline, instance *Instance, name string) bool {
+	visited := make(map[InstanceRef]bool)
+	for p != nil && instance != nil {
+		if p.Name == name {
+			return true
+		}
+		ref := instance.Parent
+		if ref == nil {
+			return false
+		}
+		key := InstanceRef{Pipeline: ref.Pipeline, ID: ref.ID}
+		if visited[key] {
+			return true
+		}
+		visited[key] = true
+		p = exec.PipelineLookup(ref.Pipeline)
+		if p == nil {
+			return false
+		}
+		instance = p.getInstance(ref.ID)
+	}
+	return false
+}
+
+// stopSubPipeline aborts the running child instance of a sub-pipeline task.
+func (exec *mrExecutor) stopSubPipeline(p *Pipeline, instance *Instance, stage int) {
+	ref, ok := instance.Children[p.instanceSpec(instance).Tasks[stage].Name]
+	if !ok || ref.Stage != stage {
+		return
+	}
+	child := exec.PipelineLookup(ref.Pipeline)
+	if child == nil {
+		return
+	}
+	if childInstance := child.getInstance(ref.ID); childInstance != nil && childInstance.isActive() {
+		exec.post(&evTaskAbort{child, ref.ID, childInstance.Stage, "Parent instance stopped", time.Now()})
+	}
+}
+
+// notifyParent completes or aborts the parent task of a child instance that
+// stopped.
+func (exec *mrExecutor) notifyParent(child *Pipeline, instance *Instance, msg string) {
+	ref := instance.Parent
+	if ref == nil {
+		return
+	}
+	p := exec.PipelineLookup(ref.Pipeline)
+	if p == nil {
+		return
+	}
+	parent := p.getInstance(ref.ID)
+	if parent == nil || !parent.isActive() || parent.Stage != ref.Stage {
+		return
+	}
+	if childRef, ok := parent.Children[p.instanceSpec(parent).Tasks[ref.Stage].Name]; ok && childRef.ID == instance.ID {
+		childRef.State = instance.State
+	}
+
+	if instance.State == StateComplete {
+		exec.post(&evTaskComplete{p, parent.ID, ref.Stage, time.Now()})
+	} else {
+		reason := fmt.Sprintf("pipeline %s:%d failed: %s", child.Name, instance.ID, msg)
+		exec.post(&evTaskAbort{p, parent.ID, ref.Stage, reason, time.Now()})
+	}
+}
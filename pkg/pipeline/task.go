@@ -21,7 +21,32 @@ type Task struct {
 	// scheduled job IDs
 	JobIDs map[string]types.UID
 
+	// Parallelism of the jobs scaled down by a pause operation.
+	Parallelism map[string]int32 `json:",omitempty"`
+
 	completed int
+
+	// Pods are the pods that executed the jobs of the task, by name.
+	Pods map[string]*PodRecord `json:",omitempty"`
+
+	// CacheKey identifies the jobs and inputs of a cached task. It is set
+	// when the task completes.
+	CacheKey string `json:",omitempty"`
+	// CachedFrom is the instance whose outputs were reused.
+	CachedFrom *InstanceRef `json:",omitempty"`
+	// cacheKey is the key of the task while it executes.
+	cacheKey string
+	// outputsVerified is set once the outputs of the task are recorded.
+	outputsVerified bool
+
+	// completePending is set when the task completes while paused.
+	completePending bool
+	// deferred is the result of a background step of the task, such as
+	// its cache key or map inputs, received while the instance is paused.
+	deferred smEvent
+
+	// done is closed to stop tasks that execute within the controller.
+	done chan struct{}
 }
 
 func (t *Task) getJobByName(name string) *batch_v1.Job {
@@ -33,11 +58,11 @@ func (t *Task) getJobByName(name string) *batch_v1.Job {
 	return nil
 }
 
-func createTaskList(config *Config, instanceID int) ([]*Task, error) {
+func createTaskList(config *Config, instance *Instance) ([]*Task, error) {
 	var taskList []*Task
 	spec := config.Spec
 	for _, taskSpec := range spec.Tasks {
-		task, err := makeTaskFromSpec(spec, instanceID, &taskSpec)
+		task, err := makeTaskFromSpec(spec, instance, &taskSpec)
 		if err != nil {
 			return nil, err
 		}
@@ -49,7 +74,7 @@ func createTaskList(config *Config, instanceID int) ([]*Task, error) {
 func (p *Pipeline) createTask(k8sClient kubernetes.Interface, instance *Instance, stage int) error {
 	task := instance.TaskList[stage]
 	for _, job := range task.jobs {
-		j, err := k8sClient.BatchV1().Jobs(p.Config.Spec.Namespace).Create(job)
+		j, err := k8sClient.BatchV1().Jobs(p.instanceSpec(instance).Namespace).Create(job)
 		if err != nil {
 			return err
 		}
@@ -84,7 +109,7 @@ func (p *Pipeline) deleteInstanceResources(k8sClient kubernetes.Interface, insta
 			"id":       strconv.Itoa(instance.ID),
 		})).String(),
 	}
-	deleteJobsAndServicesForSelector(k8sClient, p.Config.Spec.Namespace, &listOpt)
+	deleteJobsAndServicesForSelector(k8sClient, p.instanceSpec(instance).Namespace, &listOpt)
 }
 
 func (p *Pipeline) deleteTaskResources(k8sClient kubernetes.Interface, instance *Instance, taskIndex int) {
@@ -100,7 +125,7 @@ func (p *Pipeline) deleteTaskResources(k8sClient kubernetes.Interface, instance
 			"task":     "?",
 		})).String(),
 	}
-	deleteJobsAndServicesForSelector(k8sClient, p.Config.Spec.Namespace, &listOpt)
+	deleteJobsAndServicesForSelector(k8sClient, p.instanceSpec(instance).Namespace, &listOpt)
 
 }
 
@@ -115,11 +140,11 @@ func (p *Pipeline) createService(instance *Instance, task *TaskSpec, svc *Servic
 }
 
 func (p *Pipeline) createServices(instance *Instance, stage int) error {
-	if stage >= len(p.Config.Spec.Tasks) {
+	if stage >= len(p.instanceSpec(instance).Tasks) {
 		return fmt.Errorf("Invalid stage %d", stage)
 	}
 
-	taskSpec := &p.Config.Spec.Tasks[stage]
+	taskSpec := &p.instanceSpec(instance).Tasks[stage]
 
 	for i := 0; i < len(taskSpec.Services); i++ {
 		if err := p.createService(instance, taskSpec, &taskSpec.Services[i]); err != nil {
@@ -132,8 +157,12 @@ func (p *Pipeline) createServices(instance *Instance, stage int) error {
 // cancelInstance changes the number of desired job replicas to 0.
 func (p *Pipeline) cancelInstance(k8sClient kubernetes.Interface, instance *Instance) {
 	task := instance.TaskList[instance.Stage]
+	if task.done != nil {
+		close(task.done)
+		task.done = nil
+	}
 
-	jobService := k8sClient.BatchV1().Jobs(p.Config.Spec.Namespace)
+	jobService := k8sClient.BatchV1().Jobs(p.instanceSpec(instance).Namespace)
 	for _, jcfg := range task.jobs {
 		j, err := jobService.Get(jcfg.Name)
 		if err != nil {
@@ -149,3 +178,48 @@ func (p *Pipeline) cancelInstance(k8sClient kubernetes.Interface, instance *Inst
 		}
 	}
 }
+
+// pauseTask scales down the jobs of the current stage, keeping the completed
+// pods. The original parallelism is saved in the task.
+func (p *Pipeline) pauseTask(k8sClient kubernetes.Interface, instance *Instance) {
+	task := instance.TaskList[instance.Stage]
+	if task.Parallelism == nil {
+		task.Parallelism = make(map[string]int32)
+	}
+
+	jobService := k8sClient.BatchV1().Jobs(p.instanceSpec(instance).Namespace)
+	for _, jcfg := range task.jobs {
+		j, err := jobService.Get(jcfg.Name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if j.Spec.Parallelism == nil || *j.Spec.Parallelism == 0 {
+			continue
+		}
+		task.Parallelism[jcfg.Name] = *j.Spec.Parallelism
+		*j.Spec.Parallelism = 0
+		if _, err := jobService.Update(j); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// resumeTask restores the parallelism of the jobs scaled down by pauseTask.
+func (p *Pipeline) resumeTask(k8sClient kubernetes.Interface, instance *Instance) {
+	task := instance.TaskList[instance.Stage]
+
+	jobService := k8sClient.BatchV1().Jobs(p.instanceSpec(instance).Namespace)
+	for name, parallelism := range task.Parallelism {
+		j, err := jobService.Get(name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		j.Spec.Parallelism = &parallelism
+		if _, err := jobService.Update(j); err != nil {
+			log.Println(err)
+		}
+	}
+	task.Parallelism = nil
+}
@@ -2,12 +2,12 @@ package pipeline
 
 import (
 	"bytes"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
 	"strconv"
 	"strings"
+	"text/template"
 
 	api_v1 "k8s.io/client-go/pkg/api/v1"
 	batch_v1 "k8s.io/client-go/pkg/apis/batch/v1"
@@ -17,8 +17,12 @@ import (
 
 // TemplateVars defines the variables passed to the yaml template
 type TemplateVars struct {
-	Pipeline    map[string]string // Pipeline parameters
-	Task        map[string]string // Task parameters
+	Pipeline    map[string]string            // Pipeline parameters
+	Task        map[string]string            // Task parameters
+	Trigger     map[string]string            // Upstream instance that triggered the run
+	Params      map[string]string            // Instance run parameters
+	Shard       map[string]string            // Shard of a map task
+	Children    map[string]map[string]string // Sub-pipeline instances by task name
 	Instances   int
 	Parallelism int
 	Args        []string // Container arguments
@@ -56,12 +60,6 @@ func printResourceList(rlist api_v1.ResourceList, indent int) string {
 	return repr
 }
 
-var templateFuncs = template.FuncMap{
-	"isResourceSpecSet": isResourceSpecSet,
-	"isResourceListSet": isResourceListSet,
-	"printResourceList": printResourceList,
-}
-
 // pathJoin is used because filepath.Join replaces consecutive slashes such as
 // gs://path.
 func pathJoin(dir, name string) string {
@@ -83,7 +81,7 @@ func expandTemplateArgs(vars *TemplateVars, args []string) ([]string, error) {
 		return nil, nil
 	}
 	content := strings.Join(args, "\n")
-	tmpl, err := template.New("").Parse(content)
+	tmpl, err := template.New("").Funcs(templateFuncs).Parse(content)
 	if err != nil {
 		return nil, err
 	}
@@ -95,10 +93,23 @@ func expandTemplateArgs(vars *TemplateVars, args []string) ([]string, error) {
 	return strings.Split(output.String(), "\n"), nil
 }
 
-func makeTemplateVars(spec *Spec, id int, task *TaskSpec, tmpl *JobTemplate) *TemplateVars {
+func makeTemplateVars(spec *Spec, instance *Instance, task *TaskSpec, tmpl *JobTemplate) *TemplateVars {
+	tmplVars := newTemplateVars(spec, instance, task, tmpl)
+	tmplVars.expandArgs(tmpl.Args)
+	return tmplVars
+}
+
+// newTemplateVars returns the template variables without expanding the
+// container arguments.
+func newTemplateVars(spec *Spec, instance *Instance, task *TaskSpec, tmpl *JobTemplate) *TemplateVars {
+	id := instance.ID
 	tmplVars := &TemplateVars{
 		Pipeline: make(map[string]string),
 		Task:     make(map[string]string),
+		Trigger:  make(map[string]string),
+		Params:   make(map[string]string),
+		Shard:    make(map[string]string),
+		Children: make(map[string]map[string]string),
 	}
 	tmplVars.Pipeline["Name"] = spec.Name
 	tmplVars.Pipeline["TaskPrefix"] = strings.Replace(spec.Name, "_", "-", -1)
@@ -117,17 +128,36 @@ func makeTemplateVars(spec *Spec, id int, task *TaskSpec, tmpl *JobTemplate) *Te
 	if task.EtcdLock != "" {
 		tmplVars.Task["EtcdLock"] = task.EtcdLock
 	}
+	for k, v := range instance.Params {
+		tmplVars.Params[k] = v
+	}
+	for name, child := range instance.Children {
+		tmplVars.Children[name] = map[string]string{
+			"Pipeline": child.Pipeline,
+			"ID":       strconv.Itoa(child.ID),
+			"WorkDir":  child.WorkDir,
+		}
+	}
+	if trigger := instance.Trigger; trigger != nil {
+		tmplVars.Trigger["Pipeline"] = trigger.Pipeline
+		tmplVars.Trigger["ID"] = strconv.Itoa(trigger.ID)
+		tmplVars.Trigger["Task"] = trigger.Task
+		tmplVars.Trigger["WorkDir"] = trigger.WorkDir
+	}
 
 	tmplVars.Instances = tmpl.Instances
 	tmplVars.Parallelism = tmpl.Parallelism
 	tmplVars.Task["Image"] = tmpl.Image
 	tmplVars.Resources = tmpl.Resources
-	if args, err := expandTemplateArgs(tmplVars, tmpl.Args); err == nil {
-		tmplVars.Args = args
+	return tmplVars
+}
+
+func (tmplVars *TemplateVars) expandArgs(args []string) {
+	if expanded, err := expandTemplateArgs(tmplVars, args); err == nil {
+		tmplVars.Args = expanded
 	} else {
 		log.Println(err)
 	}
-	return tmplVars
 }
 
 func makeServiceVars(spec *Spec, id int, task *TaskSpec, svc *ServiceSpec) *ServiceVars {
@@ -173,10 +203,14 @@ func createK8SConfig(tmplFile string, writer io.Writer, vars interface{}) error
 	return tmpl.Execute(writer, vars)
 }
 
-func makeK8SJobSpecFromSpec(spec *Spec, instanceID int, taskSpec *TaskSpec, jspec *JobTemplate) (*batch_v1.Job, error) {
+func makeK8SJobSpecFromSpec(spec *Spec, instance *Instance, taskSpec *TaskSpec, jspec *JobTemplate) (*batch_v1.Job, error) {
+	vars := makeTemplateVars(spec, instance, taskSpec, jspec)
+	return makeK8SJob(jspec.Template, vars)
+}
+
+func makeK8SJob(tmplFile string, vars *TemplateVars) (*batch_v1.Job, error) {
 	buffer := new(bytes.Buffer)
-	vars := makeTemplateVars(spec, instanceID, taskSpec, jspec)
-	if err := createK8SConfig(jspec.Template, buffer, vars); err != nil {
+	if err := createK8SConfig(tmplFile, buffer, vars); err != nil {
 		return nil, err
 	}
 
@@ -189,11 +223,16 @@ func makeK8SJobSpecFromSpec(spec *Spec, instanceID int, taskSpec *TaskSpec, jspe
 }
 
 // makeTaskFromSpec creates a Task from the pipeline spec and templates.
-func makeTaskFromSpec(spec *Spec, instanceID int, taskSpec *TaskSpec) (*Task, error) {
+func makeTaskFromSpec(spec *Spec, instance *Instance, taskSpec *TaskSpec) (*Task, error) {
 	var jobs []*batch_v1.Job
 
+	// the jobs of a map task are generated when the task executes
+	if taskSpec.Map != nil {
+		return &Task{JobIDs: make(map[string]types.UID)}, nil
+	}
+
 	for _, jspec := range taskSpec.JobSpecs() {
-		job, err := makeK8SJobSpecFromSpec(spec, instanceID, taskSpec, jspec)
+		job, err := makeK8SJobSpecFromSpec(spec, instance, taskSpec, jspec)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,260 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// templateFuncs is the function library available to job and service
+// templates, as well as to container arguments.
+//
+//	default DEFAULT VALUE   VALUE, or DEFAULT when VALUE is empty
+//	quote VALUE             VALUE as a double quoted string
+//	toYaml VALUE            VALUE encoded as a block style YAML document
+//	indent N STRING         STRING with every line indented by N spaces
+//	nindent N STRING        same as indent but preceded by a newline
+//	env NAME                value of the pipeman environment variable NAME
+//	envOr NAME DEFAULT      same as env but returns DEFAULT when NAME is not set
+//	join SEP LIST           elements of LIST separated by SEP
+//	upper STRING            STRING in upper case
+//	lower STRING            STRING in lower case
+//	required MSG VALUE      VALUE, or an error with MSG when VALUE is empty
+//
+// Only the environment variables allowed with AllowTemplateEnv can be read by
+// env and envOr, so that templates can't copy controller credentials into
+// job manifests.
+//
+// The resource helpers isResourceSpecSet, isResourceListSet and
+// printResourceList are kept for existing templates; new templates should use
+// toYaml and indent instead.
+var templateFuncs = template.FuncMap{
+	"isResourceSpecSet": isResourceSpecSet,
+	"isResourceListSet": isResourceListSet,
+	"printResourceList": printResourceList,
+
+	"default":  defaultValue,
+	"quote":    quote,
+	"toYaml":   toYaml,
+	"indent":   indent,
+	"nindent":  nindent,
+	"env":      env,
+	"envOr":    envOr,
+	"join":     join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"required": required,
+}
+
+func isEmptyValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func defaultValue(def, v interface{}) interface{} {
+	if isEmptyValue(v) {
+		return def
+	}
+	return v
+}
+
+func quote(v interface{}) string {
+	if v == nil {
+		return `""`
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func nindent(n int, s string) string {
+	return "\n" + indent(n, s)
+}
+
+// templateEnv contains the names of the environment variables that templates
+// are allowed to read.
+var templateEnv = struct {
+	sync.Mutex
+	names map[string]bool
+}{
+	names: make(map[string]bool),
+}
+
+// AllowTemplateEnv allows templates to read the environment variable name.
+func AllowTemplateEnv(name string) {
+	templateEnv.Lock()
+	defer templateEnv.Unlock()
+	templateEnv.names[name] = true
+}
+
+func lookupTemplateEnv(name string) (string, bool, error) {
+	templateEnv.Lock()
+	allowed := templateEnv.names[name]
+	templateEnv.Unlock()
+	if !allowed {
+		return "", false, fmt.Errorf("environment variable %s is not allowed in templates", name)
+	}
+	v, ok := os.LookupEnv(name)
+	return v, ok, nil
+}
+
+func env(name string) (string, error) {
+	v, _, err := lookupTemplateEnv(name)
+	return v, err
+}
+
+func envOr(name, def string) (string, error) {
+	v, ok, err := lookupTemplateEnv(name)
+	if err != nil || !ok {
+		return def, err
+	}
+	return v, nil
+}
+
+func join(sep string, v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if list, ok := v.([]string); ok {
+		return strings.Join(list, sep), nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: unsupported type %T", v)
+	}
+	elements := make([]string, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		elements[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(elements, sep), nil
+}
+
+func required(msg string, v interface{}) (interface{}, error) {
+	if isEmptyValue(v) {
+		return nil, fmt.Errorf("required value: %s", msg)
+	}
+	return v, nil
+}
+
+// toYaml encodes a value in block style YAML. The value is first converted to
+// JSON so that the encoding follows the json struct tags of k8s API objects.
+func toYaml(v interface{}) (string, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+	var obj interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	writeYaml(&buf, obj, 0)
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func isYamlCollection(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return len(t) > 0
+	case []interface{}:
+		return len(t) > 0
+	}
+	return false
+}
+
+func writeYaml(buf *bytes.Buffer, v interface{}, level int) {
+	pad := strings.Repeat(" ", level)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			buf.WriteString(pad + "{}\n")
+			return
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if isYamlCollection(t[k]) {
+				buf.WriteString(pad + yamlScalar(k) + ":\n")
+				writeYaml(buf, t[k], level+2)
+			} else {
+				buf.WriteString(pad + yamlScalar(k) + ": " + yamlScalar(t[k]) + "\n")
+			}
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			buf.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range t {
+			if !isYamlCollection(item) {
+				buf.WriteString(pad + "- " + yamlScalar(item) + "\n")
+				continue
+			}
+			// place the first line of the nested collection after the "- "
+			var nested bytes.Buffer
+			writeYaml(&nested, item, level+2)
+			buf.WriteString(pad + "- " + nested.String()[level+2:])
+		}
+	default:
+		buf.WriteString(pad + yamlScalar(v) + "\n")
+	}
+}
+
+var (
+	yamlPlainRe    = regexp.MustCompile(`^-?[A-Za-z_/][A-Za-z0-9_./=-]*$`)
+	yamlReservedRe = regexp.MustCompile(`^(?i:y|yes|n|no|true|false|on|off|null)$`)
+)
+
+func yamlScalar(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(t)
+	case json.Number:
+		return t.String()
+	case string:
+		if yamlPlainRe.MatchString(t) && !yamlReservedRe.MatchString(t) {
+			return t
+		}
+		return strconv.Quote(t)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
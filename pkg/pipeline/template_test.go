@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 	"text/template"
 )
@@ -46,7 +47,7 @@ func TestTemplateVars(t *testing.T) {
 		}
 		var buf bytes.Buffer
 		err = tmpl.Execute(&buf, makeTemplateVars(
-			test.spec, 1, &test.spec.Tasks[0], &test.spec.Tasks[0].JobTemplate))
+			test.spec, makeInstance(1), &test.spec.Tasks[0], &test.spec.Tasks[0].JobTemplate))
 		if err != nil {
 			t.Error(err)
 			continue
@@ -89,7 +90,7 @@ func TestDefaultTemplate(t *testing.T) {
 		}
 		taskSpec := &spec.Tasks[test.stage-1]
 
-		task, err := makeTaskFromSpec(spec, 1, taskSpec)
+		task, err := makeTaskFromSpec(spec, makeInstance(1), taskSpec)
 		if err != nil {
 			t.Error(err)
 			continue
@@ -173,3 +174,64 @@ func TestServiceTemplate(t *testing.T) {
 		// }
 	}
 }
+
+func TestTemplateFuncs(t *testing.T) {
+	os.Setenv("TEST_TEMPLATE_FUNCS", "value")
+	AllowTemplateEnv("TEST_TEMPLATE_FUNCS")
+	AllowTemplateEnv("TEST_TEMPLATE_FUNCS_UNSET")
+	testCases := []struct {
+		template string
+		data     interface{}
+		expected string
+	}{
+		{`{{ default "x" .}}`, "", "x"},
+		{`{{ default "x" .}}`, "y", "y"},
+		{`{{ quote . }}`, `a&b <"c">`, `"a&b <\"c\">"`},
+		{`{{ join "," . }}`, []string{"a", "b"}, "a,b"},
+		{`{{ upper . }}-{{ lower . }}`, "Ab", "AB-ab"},
+		{`{{ env "TEST_TEMPLATE_FUNCS" }}`, nil, "value"},
+		{`{{ envOr "TEST_TEMPLATE_FUNCS_UNSET" "def" }}`, nil, "def"},
+		{`{{ indent 2 . }}`, "a\nb", "  a\n  b"},
+		{
+			`{{ toYaml . }}`,
+			map[string]interface{}{
+				"args":   []string{"-v=3", "a: b"},
+				"memory": "12Gi",
+				"count":  2,
+				"env":    []map[string]string{{"name": "A", "value": "true"}},
+			},
+			"args:\n  - -v=3\n  - \"a: b\"\ncount: 2\nenv:\n  - name: A\n    value: \"true\"\nmemory: \"12Gi\"",
+		},
+	}
+
+	for i := range testCases {
+		test := &testCases[i]
+		tmpl, err := template.New("").Funcs(templateFuncs).Parse(test.template)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, test.data); err != nil {
+			t.Error(err)
+			continue
+		}
+		if result := buf.String(); result != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, result)
+		}
+	}
+
+	tmpl := template.Must(template.New("").Funcs(templateFuncs).Parse(`{{ required "value" . }}`))
+	if err := tmpl.Execute(ioutil.Discard, ""); err == nil {
+		t.Error("expected error for required value")
+	}
+
+	os.Setenv("TEST_TEMPLATE_FUNCS_SECRET", "secret")
+	for _, text := range []string{`{{ env "TEST_TEMPLATE_FUNCS_SECRET" }}`, `{{ envOr "TEST_TEMPLATE_FUNCS_SECRET" "def" }}`} {
+		tmpl := template.Must(template.New("").Funcs(templateFuncs).Parse(text))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err == nil || strings.Contains(buf.String(), "secret") {
+			t.Errorf("%s: expected error, got %q", text, buf.String())
+		}
+	}
+}
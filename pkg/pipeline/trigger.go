@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+)
+
+// isTriggeredBy returns true if the trigger matches the completion of the
+// upstream pipeline instance (taskName == "") or of one of its tasks.
+func (t *TriggerSpec) isTriggeredBy(upstream, taskName string) bool {
+	return t.Pipeline == upstream && t.Task == taskName
+}
+
+// checkTriggerCycle returns an error when installing spec as the
+// configuration of the named pipeline makes it trigger itself through its
+// upstream pipelines. Must be called with the lock held.
+func (exec *mrExecutor) checkTriggerCycle(name string, spec *Spec) error {
+	triggers := func(pipeline string) []TriggerSpec {
+		if pipeline == name {
+			return spec.Triggers
+		}
+		if p := exec.pipelines[pipeline]; p != nil && p.Config != nil {
+			return p.Config.Spec.Triggers
+		}
+		return nil
+	}
+
+	visited := make(map[string]bool)
+	pending := []string{name}
+	for len(pending) > 0 {
+		pipeline := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, t := range triggers(pipeline) {
+			if t.Pipeline == name {
+				return fmt.Errorf("Pipeline %s is triggered by itself through %s", name, pipeline)
+			}
+			if !visited[t.Pipeline] {
+				visited[t.Pipeline] = true
+				pending = append(pending, t.Pipeline)
+			}
+		}
+	}
+	return nil
+}
+
+// fireTriggers starts the pipelines that are triggered by the completion of
+// an instance of p or of one of its tasks.
+func (exec *mrExecutor) fireTriggers(p *Pipeline, instance *Instance, taskName string) {
+	upstream := &InstanceTrigger{
+		Pipeline: p.Name,
+		ID:       instance.ID,
+		Task:     taskName,
+		WorkDir:  p.instanceWorkDir(instance),
+	}
+
+	var triggered []*Pipeline
+	exec.Lock()
+	for _, downstream := range exec.pipelines {
+		for i := range downstream.Config.Spec.Triggers {
+			if downstream.Config.Spec.Triggers[i].isTriggeredBy(p.Name, taskName) {
+				triggered = append(triggered, downstream)
+				break
+			}
+		}
+	}
+	exec.Unlock()
+
+	for _, downstream := range triggered {
+		instance, err := exec.createInstance(downstream, &instanceOptions{trigger: upstream})
+		if err != nil {
+			log.Printf("%s: %v", downstream.Name, err)
+			continue
+		}
+		exec.post(&evPipelineRun{downstream, instance.ID, 0})
+	}
+}
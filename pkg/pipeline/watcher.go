@@ -29,11 +29,14 @@ func (w *Watcher) runOnce(podWatcher, jobWatcher watch.Interface, eventChan chan
 			return false
 		}
 		switch ev.Type {
-		case watch.Added:
-		case watch.Deleted:
-		case watch.Modified:
+		case watch.Added, watch.Modified:
 			pod := ev.Object.(*api_v1.Pod)
-			log.Println("POD", ev.Type, pod.Name)
+			eventChan <- &evPodStatus{
+				w.pipeline,
+				w.instance.ID,
+				pod,
+			}
+		case watch.Deleted:
 		}
 
 	case ev, ok := <-jobWatcher.ResultChan():
@@ -62,11 +65,11 @@ func (w *Watcher) runOnce(podWatcher, jobWatcher watch.Interface, eventChan chan
 }
 
 func (w *Watcher) createWatchers(clientset kubernetes.Interface) (watch.Interface, watch.Interface, error) {
-	podWatcher, err := clientset.Core().Pods(w.pipeline.Config.Spec.Namespace).Watch(w.selector)
+	podWatcher, err := clientset.Core().Pods(w.pipeline.instanceSpec(w.instance).Namespace).Watch(w.selector)
 	if err != nil {
 		return nil, nil, err
 	}
-	jobWatcher, err := clientset.BatchV1().Jobs(w.pipeline.Config.Spec.Namespace).Watch(w.selector)
+	jobWatcher, err := clientset.BatchV1().Jobs(w.pipeline.instanceSpec(w.instance).Namespace).Watch(w.selector)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxWorkDirPage is the maximum number of objects in a work directory page.
+const maxWorkDirPage = 1000
+
+// WorkDirObject describes an object in the work directory of an instance.
+type WorkDirObject struct {
+	// Name is the object path relative to the work directory.
+	Name    string
+	Size    int64
+	Updated time.Time
+}
+
+// WorkDirListing is a page of the objects in an instance work directory.
+type WorkDirListing struct {
+	WorkDir string
+	Objects []WorkDirObject
+	// Next is the token of the following page, empty on the last page.
+	Next string `json:",omitempty"`
+}
+
+// checkWorkDirName verifies that a name or prefix refers to a path inside the
+// work directory.
+func checkWorkDirName(name string) error {
+	if strings.HasPrefix(name, "/") {
+		return fmt.Errorf("invalid path: %s", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return fmt.Errorf("invalid path: %s", name)
+		}
+	}
+	return nil
+}
+
+// listWorkDir returns a page of at most max objects of the work directory
+// whose name starts with prefix. The token identifies the page and is empty
+// for the first one.
+func listWorkDir(workDir, prefix, token string, max int) (*WorkDirListing, error) {
+	if err := checkWorkDirName(prefix); err != nil {
+		return nil, err
+	}
+	backend, err := storageFor(workDir)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	root := strings.TrimSuffix(workDir, "/") + "/"
+	objects, next, err := listPage(ctx, backend, root+prefix, token, max)
+	if err != nil {
+		return nil, err
+	}
+	listing := &WorkDirListing{WorkDir: workDir, Next: next}
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.URI, root) {
+			continue
+		}
+		listing.Objects = append(listing.Objects, WorkDirObject{
+			Name:    obj.URI[len(root):],
+			Size:    obj.Size,
+			Updated: obj.ModTime,
+		})
+	}
+	return listing, nil
+}
+
+// statWorkDirObject returns the URI and attributes of an object in the work
+// directory. Symbolic links in local work directories are not followed.
+func statWorkDirObject(workDir, name string) (string, *ObjectInfo, error) {
+	if name == "" || strings.HasSuffix(name, "/") || path.Clean(name) != name {
+		return "", nil, fmt.Errorf("invalid path: %s", name)
+	}
+	if err := checkWorkDirName(name); err != nil {
+		return "", nil, err
+	}
+	uri := pathJoin(workDir, name)
+	if strings.HasPrefix(uri, fileScheme) {
+		info, err := os.Lstat(filePath(uri))
+		if err != nil {
+			return "", nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return "", nil, notExist("open", uri)
+		}
+		if err := checkLocalTarget(filePath(workDir), name); err != nil {
+			return "", nil, err
+		}
+		return uri, &ObjectInfo{URI: uri, Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+
+	backend, err := storageFor(uri)
+	if err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+	info, err := backend.Stat(ctx, uri)
+	if err != nil {
+		return "", nil, err
+	}
+	return uri, info, nil
+}
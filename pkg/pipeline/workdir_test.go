@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListWorkDir(t *testing.T) {
+	RegisterStorage("mem", newMemStorage())
+	writeObject(t, "mem://work/1/data/part-0", "0")
+	writeObject(t, "mem://work/1/data/part-1", "11")
+	writeObject(t, "mem://work/1/conf/config.yaml", "name: test")
+	writeObject(t, "mem://work/10/data/part-0", "0")
+
+	listing, err := listWorkDir("mem://work/1", "data/", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := listing.Objects
+	if len(objects) != 2 || objects[0].Name != "data/part-0" || objects[1].Name != "data/part-1" || objects[1].Size != 2 {
+		t.Errorf("unexpected objects %+v", objects)
+	}
+	if listing.Next != "" {
+		t.Errorf("unexpected next page %q", listing.Next)
+	}
+	if listing, _ := listWorkDir("mem://work/1", "", "", 100); len(listing.Objects) != 3 {
+		t.Errorf("expected 3 objects, got %+v", listing.Objects)
+	}
+	if _, err := listWorkDir("mem://work/1", "../10/", "", 100); err == nil {
+		t.Error("expected an error for a prefix outside of the work dir")
+	}
+
+	var names []string
+	for token := ""; ; {
+		listing, err := listWorkDir("mem://work/1", "", token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range listing.Objects {
+			names = append(names, obj.Name)
+		}
+		if token = listing.Next; token == "" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "conf/config.yaml,data/part-0,data/part-1" {
+		t.Errorf("unexpected pages %v", names)
+	}
+
+	if _, info, err := statWorkDirObject("mem://work/1", "data/part-1"); err != nil || info.Size != 2 {
+		t.Errorf("stat: %v %v", info, err)
+	}
+	for _, name := range []string{"", "data/", "/data/part-0", "data/../../10/data/part-0", "./data/part-0"} {
+		if _, _, err := statWorkDirObject("mem://work/1", name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestWorkDirSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	workDir := filepath.Join(dir, "1")
+	os.MkdirAll(workDir, 0755)
+	ioutil.WriteFile(filepath.Join(workDir, "output"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0600)
+	os.Symlink(filepath.Join(dir, "secret"), filepath.Join(workDir, "link"))
+
+	listing, err := listWorkDir(fileScheme+workDir, "", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects := listing.Objects; len(objects) != 1 || objects[0].Name != "output" {
+		t.Errorf("unexpected objects %+v", listing.Objects)
+	}
+	if _, _, err := statWorkDirObject(fileScheme+workDir, "output"); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := statWorkDirObject(fileScheme+workDir, "link"); !os.IsNotExist(err) {
+		t.Errorf("expected symlink to be hidden: %v", err)
+	}
+}
+
+func TestWorkDirPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := []string{"a/b", "a/c/d", "a/c/e", "a-b", "b"}
+	for _, name := range expected {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(name), 0644)
+	}
+
+	var names []string
+	for token := ""; ; {
+		listing, err := listWorkDir(fileScheme+dir, "", token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listing.Objects) > 2 {
+			t.Errorf("page size exceeded: %+v", listing.Objects)
+		}
+		for _, obj := range listing.Objects {
+			names = append(names, obj.Name)
+		}
+		if token = listing.Next; token == "" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected pages %v", names)
+	}
+}